@@ -0,0 +1,607 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/putchi/go-lib-utils/internal/copierfields"
+)
+
+const annotation = "//copiergen:copy"
+
+// copyPair is an annotated pair of struct types and the name of the function copying them
+type copyPair struct {
+	from, to *types.Named
+	name     string
+}
+
+type generator struct {
+	pkg     *types.Package
+	pairs   []copyPair
+	imports map[string]bool
+	// nonNil holds the source expressions checked for nil already
+	nonNil map[string]bool
+	body   bytes.Buffer
+}
+
+// generate returns the source of the copy functions of the pairs annotated in the package in dir
+func generate(dir, output string) ([]byte, error) {
+	fset := token.NewFileSet()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []*ast.File
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") || name == output {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Go files in %s", dir)
+	}
+
+	// the package may use the functions about to be generated, so type errors are ignored
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil), Error: func(error) {}}
+	pkg, _ := conf.Check(files[0].Name.Name, fset, files, nil)
+
+	g := &generator{pkg: pkg, imports: map[string]bool{}, nonNil: map[string]bool{}}
+	for _, file := range files {
+		for _, group := range file.Comments {
+			for _, comment := range group.List {
+				if !strings.HasPrefix(comment.Text, annotation+" ") {
+					continue
+				}
+				if err = g.addPair(strings.Fields(strings.TrimPrefix(comment.Text, annotation))); err != nil {
+					return nil, fmt.Errorf("%s: %w", fset.Position(comment.Pos()), err)
+				}
+			}
+		}
+	}
+	if len(g.pairs) == 0 {
+		return nil, fmt.Errorf("no %s annotations in %s", annotation, dir)
+	}
+
+	for _, pair := range g.pairs {
+		if err = g.copyFunc(pair); err != nil {
+			return nil, fmt.Errorf("%s: %w", pair.name, err)
+		}
+	}
+	return g.source()
+}
+
+// addPair adds the pair of the annotation arguments: the source type, the destination type and the function name
+func (g *generator) addPair(args []string) error {
+	if len(args) != 2 && len(args) != 3 {
+		return fmt.Errorf("%s takes the types to copy from and into, and optionally a function name", annotation)
+	}
+	from, err := g.structType(args[0])
+	if err != nil {
+		return err
+	}
+	to, err := g.structType(args[1])
+	if err != nil {
+		return err
+	}
+	name := "Copy" + args[0] + "To" + args[1]
+	if len(args) == 3 {
+		name = args[2]
+	}
+	g.pairs = append(g.pairs, copyPair{from: from, to: to, name: name})
+	return nil
+}
+
+func (g *generator) structType(name string) (*types.Named, error) {
+	if typeName, ok := g.pkg.Scope().Lookup(name).(*types.TypeName); ok {
+		if named, ok := typeName.Type().(*types.Named); ok {
+			if _, ok = named.Underlying().(*types.Struct); ok {
+				return named, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s is not a struct type of package %s", name, g.pkg.Name())
+}
+
+func (g *generator) pairFunc(from, to types.Type) (string, bool) {
+	for _, pair := range g.pairs {
+		if types.Identical(pair.from, from) && types.Identical(pair.to, to) {
+			return pair.name, true
+		}
+	}
+	return "", false
+}
+
+func (g *generator) source() ([]byte, error) {
+	var source bytes.Buffer
+	fmt.Fprintf(&source, "// Code generated by copiergen. DO NOT EDIT.\n\npackage %s\n\n", g.pkg.Name())
+	if len(g.imports) > 0 {
+		paths := make([]string, 0, len(g.imports))
+		for path := range g.imports {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		fmt.Fprintf(&source, "import (\n")
+		for _, path := range paths {
+			fmt.Fprintf(&source, "%q\n", path)
+		}
+		fmt.Fprintf(&source, ")\n\n")
+	}
+	source.Write(g.body.Bytes())
+	return format.Source(source.Bytes())
+}
+
+func (g *generator) typeString(t types.Type) string {
+	return types.TypeString(t, func(pkg *types.Package) string {
+		if pkg == g.pkg {
+			return ""
+		}
+		g.imports[pkg.Path()] = true
+		return pkg.Name()
+	})
+}
+
+// structField is a field listed by utils.deepFields
+type structField struct {
+	*types.Var
+	tag copierfields.Tag
+}
+
+// copyName is the name the field is matched by
+func (field structField) copyName() string {
+	return field.tag.CopyName(field.Name())
+}
+
+// indirectType dereferences pointers and slices like utils.IndirectType
+func indirectType(t types.Type) types.Type {
+	for {
+		switch indirect := t.(type) {
+		case *types.Pointer:
+			t = indirect.Elem()
+		case *types.Slice:
+			t = indirect.Elem()
+		default:
+			return t
+		}
+	}
+}
+
+func isStruct(t types.Type) bool {
+	_, ok := t.Underlying().(*types.Struct)
+	return ok
+}
+
+// deepFields lists the fields of a struct, replacing embedded structs by their fields like utils.deepFields
+func deepFields(t types.Type) []structField {
+	return copierfields.Deep(t, structFields, func(field structField) (types.Type, bool) {
+		return field.Type(), field.Embedded()
+	})
+}
+
+// structFields lists the direct fields of a struct, or of the struct a pointer or slice points to
+func structFields(t types.Type) []structField {
+	structType, ok := indirectType(t).Underlying().(*types.Struct)
+	if !ok {
+		return nil
+	}
+	fields := make([]structField, structType.NumFields())
+	for i := range fields {
+		fields[i] = structField{Var: structType.Field(i), tag: copierfields.ParseTag(reflect.StructTag(structType.Tag(i)))}
+	}
+	return fields
+}
+
+// visibleFields lists the fields of a struct reachable by name like utils.visibleFields,
+// leaving out the fields shadowed or ambiguous
+func visibleFields(t types.Type) []structField {
+	fields := deepFields(t)
+	return copierfields.Visible(fields, func(field structField) string {
+		return field.Name()
+	}, func(name string) (structField, bool) {
+		var object types.Object
+		for _, field := range fields {
+			if field.Name() != name {
+				continue
+			}
+			if object == nil {
+				// unexported names are only found in their own package
+				object, _, _ = types.LookupFieldOrMethod(t, true, field.Pkg(), name)
+			}
+			if field.Var == object {
+				return field, true
+			}
+		}
+		return structField{}, false
+	})
+}
+
+// fieldByCopyName finds the field matched by the name, like utils.fieldByCopyName
+func fieldByCopyName(t types.Type, name string) (structField, bool) {
+	for _, field := range visibleFields(t) {
+		if field.copyName() == name {
+			return field, true
+		}
+	}
+	return structField{}, false
+}
+
+// embeddedPointer is an embedded pointer leading to a promoted field
+type embeddedPointer struct {
+	expr string
+	elem types.Type
+}
+
+// embeddedPointers lists the embedded pointers of base leading to the promoted field
+func (g *generator) embeddedPointers(base string, t *types.Named, name string) []embeddedPointer {
+	_, index, _ := types.LookupFieldOrMethod(t, false, g.pkg, name)
+	var pointers []embeddedPointer
+	current, expr := types.Type(t), base
+	for _, x := range index[:len(index)-1] {
+		field := current.Underlying().(*types.Struct).Field(x)
+		expr += "." + field.Name()
+		current = field.Type()
+		if pointer, ok := current.(*types.Pointer); ok {
+			pointers = append(pointers, embeddedPointer{expr: expr, elem: pointer.Elem()})
+			current = pointer.Elem()
+		}
+	}
+	return pointers
+}
+
+// checkEmbedded skips fields promoted through nil embedded pointers of the source, returning the closing braces
+func (g *generator) checkEmbedded(from *types.Named, name string) string {
+	pointers := g.embeddedPointers("from", from, name)
+	for _, pointer := range pointers {
+		fmt.Fprintf(&g.body, "if %s != nil {\n", pointer.expr)
+	}
+	return strings.Repeat("}\n", len(pointers))
+}
+
+// allocEmbedded allocates the nil embedded pointers leading to the destination field, like utils.fieldByIndexAlloc
+func (g *generator) allocEmbedded(to *types.Named, name string) {
+	for _, pointer := range g.embeddedPointers("to", to, name) {
+		fmt.Fprintf(&g.body, "if %s == nil {\n%s = new(%s)\n}\n", pointer.expr, pointer.expr, g.typeString(pointer.elem))
+	}
+}
+
+// copyFunc emits the function of the pair, following the copy plans of utils.Copy
+func (g *generator) copyFunc(pair copyPair) error {
+	if isValuer(pair.from) {
+		return fmt.Errorf("copying the driver.Valuer %s needs reflection", pair.from.Obj().Name())
+	}
+	fromName, toName := g.typeString(pair.from), g.typeString(pair.to)
+	fmt.Fprintf(&g.body, "// %s copies %s into %s like utils.Copy does, without reflection\n", pair.name, fromName, toName)
+	fmt.Fprintf(&g.body, "func %s(to *%s, from *%s) error {\nif from == nil {\nreturn nil\n}\n", pair.name, toName, fromName)
+	g.nonNil = map[string]bool{}
+
+	copiedFrom := map[string]bool{}
+	for _, field := range visibleFields(pair.from) {
+		if field.tag.Ignore {
+			continue
+		}
+
+		toField, ok := fieldByCopyName(pair.to, field.copyName())
+		if !ok {
+			if field.Exported() {
+				g.setter(pair, field)
+			}
+			continue
+		}
+		if toField.tag.Ignore {
+			continue
+		}
+		copiedFrom[toField.Name()] = true
+		if !field.Exported() || !toField.Exported() {
+			continue
+		}
+		if err := g.field(pair, field, toField); err != nil {
+			return fmt.Errorf("copying %s.%s into %s.%s: %w", fromName, field.Name(), toName, toField.Name(), err)
+		}
+	}
+
+	for _, field := range visibleFields(pair.to) {
+		if field.tag.Ignore || copiedFrom[field.Name()] {
+			continue
+		}
+		found, err := g.getter(pair, field)
+		if err != nil {
+			return fmt.Errorf("copying %s.%s() into %s.%s: %w", fromName, field.copyName(), toName, field.Name(), err)
+		}
+		if !found && field.tag.Must {
+			return fmt.Errorf("field %s.%s is tagged must but %s has no field or method %q to copy from",
+				toName, field.Name(), fromName, field.copyName())
+		}
+	}
+	fmt.Fprintf(&g.body, "return nil\n}\n\n")
+	return nil
+}
+
+// field emits the copy of a source field into the destination field
+func (g *generator) field(pair copyPair, from, to structField) error {
+	closing := g.checkEmbedded(pair.from, from.Name())
+	g.allocEmbedded(pair.to, to.Name())
+	err := g.set("to."+to.Name(), to.Type(), "from."+from.Name(), from.Type(), true)
+	fmt.Fprint(&g.body, closing)
+	return err
+}
+
+// setter emits the call of the destination setter the source field is copied into, if there is one
+func (g *generator) setter(pair copyPair, from structField) {
+	for _, name := range []string{from.copyName(), "Set" + from.copyName()} {
+		method, ok := g.method(pair.to, name)
+		if !ok {
+			continue
+		}
+		signature := method.Type().(*types.Signature)
+		if signature.Params().Len() != 1 || !types.AssignableTo(from.Type(), signature.Params().At(0).Type()) ||
+			!returnsNothingOrError(signature, 0) {
+			continue
+		}
+		closing := g.checkEmbedded(pair.from, from.Name())
+		if signature.Results().Len() == 0 {
+			fmt.Fprintf(&g.body, "to.%s(from.%s)\n", name, from.Name())
+		} else {
+			fmt.Fprintf(&g.body, "if err := to.%s(from.%s); err != nil {\nreturn err\n}\n", name, from.Name())
+		}
+		fmt.Fprint(&g.body, closing)
+		return
+	}
+}
+
+// getter emits the copy of the result of the source method named like the destination field, if there is one
+func (g *generator) getter(pair copyPair, to structField) (bool, error) {
+	method, ok := g.method(pair.from, to.copyName())
+	if !ok {
+		return false, nil
+	}
+	signature := method.Type().(*types.Signature)
+	if signature.Params().Len() != 0 || signature.Results().Len() == 0 || !returnsNothingOrError(signature, 1) {
+		return false, nil
+	}
+	if !to.Exported() {
+		return true, nil
+	}
+
+	fmt.Fprintf(&g.body, "{\n")
+	g.allocEmbedded(pair.to, to.Name())
+	if signature.Results().Len() == 1 {
+		fmt.Fprintf(&g.body, "value := from.%s()\n", to.copyName())
+	} else {
+		fmt.Fprintf(&g.body, "value, err := from.%s()\nif err != nil {\nreturn err\n}\n", to.copyName())
+	}
+	// the result is set like a field, without falling back to copying it field by field
+	err := g.set("to."+to.Name(), to.Type(), "value", signature.Results().At(0).Type(), false)
+	delete(g.nonNil, "value")
+	fmt.Fprintf(&g.body, "}\n")
+	return true, err
+}
+
+func (g *generator) method(t *types.Named, name string) (*types.Func, bool) {
+	if !token.IsExported(name) {
+		return nil, false
+	}
+	object, _, _ := types.LookupFieldOrMethod(t, true, g.pkg, name)
+	method, ok := object.(*types.Func)
+	return method, ok
+}
+
+// set emits the copy of from into to like utils.set, followed by the copy Copy falls back to when
+// set cannot assign the value and fallback is set
+func (g *generator) set(to string, toType types.Type, from string, fromType types.Type, fallback bool) error {
+	pointer, ok := toType.(*types.Pointer)
+	if isValuer(fromType) && !types.ConvertibleTo(fromType, toType) && !(ok && types.ConvertibleTo(fromType, pointer.Elem())) {
+		return fmt.Errorf("copying the driver.Valuer %s needs reflection", g.typeString(fromType))
+	}
+	if !ok {
+		return g.setValue(to, toType, from, fromType, fallback)
+	}
+	closing := ""
+	if _, ok = fromType.(*types.Pointer); ok && !g.nonNil[from] {
+		fmt.Fprintf(&g.body, "if %s == nil {\n%s = nil\n} else {\n", from, to)
+		g.nonNil[from] = true
+		defer delete(g.nonNil, from)
+		closing = "}\n"
+	}
+	fmt.Fprintf(&g.body, "if %s == nil {\n%s = new(%s)\n}\n", to, to, g.typeString(pointer.Elem()))
+	err := g.setValue("*"+to, pointer.Elem(), from, fromType, fallback)
+	fmt.Fprint(&g.body, closing)
+	return err
+}
+
+// setValue emits the copy of from into the value to like utils.setStep2
+func (g *generator) setValue(to string, toType types.Type, from string, fromType types.Type, fallback bool) error {
+	fromPointer, isPointer := fromType.(*types.Pointer)
+	switch {
+	case types.AssignableTo(fromType, toType):
+		fmt.Fprintf(&g.body, "%s = %s\n", to, from)
+	case types.ConvertibleTo(fromType, toType):
+		if isInteger(fromType) && isString(toType) {
+			from = "rune(" + from + ")"
+		}
+		fmt.Fprintf(&g.body, "%s = %s(%s)\n", to, g.typeString(toType), from)
+	case isScanner(toType):
+		// scanner errors are ignored, like Copy does without CopyOption.Strict
+		fmt.Fprintf(&g.body, "_ = %s.Scan(%s)\n", selector(to), from)
+		if fallback {
+			return g.copyInto(to, toType, from, fromType)
+		}
+	case isPointer:
+		if g.nonNil[from] {
+			return g.set(to, toType, "*"+from, fromPointer.Elem(), fallback)
+		}
+		fmt.Fprintf(&g.body, "if %s != nil {\n", from)
+		g.nonNil[from] = true
+		err := g.set(to, toType, "*"+from, fromPointer.Elem(), fallback)
+		delete(g.nonNil, from)
+		fmt.Fprintf(&g.body, "}\n")
+		return err
+	case fallback:
+		return g.copyInto(to, toType, from, fromType)
+	}
+	return nil
+}
+
+// copyInto emits the copy of from into to like utils.CopyWithOption, for values set cannot assign
+func (g *generator) copyInto(to string, toType types.Type, from string, fromType types.Type) error {
+	if pointer, ok := fromType.(*types.Pointer); ok {
+		if g.nonNil[from] {
+			return g.copyInto(to, toType, "*"+from, pointer.Elem())
+		}
+		fmt.Fprintf(&g.body, "if %s != nil {\n", from)
+		err := g.copyInto(to, toType, "*"+from, pointer.Elem())
+		fmt.Fprintf(&g.body, "}\n")
+		return err
+	}
+
+	fromKind, toKind := fromType.Underlying(), toType.Underlying()
+	_, fromMap := fromKind.(*types.Map)
+	_, toMap := toKind.(*types.Map)
+	_, fromSlice := fromKind.(*types.Slice)
+	_, toSlice := toKind.(*types.Slice)
+	_, fromArray := fromKind.(*types.Array)
+	fromStruct, toStruct := isStruct(indirectType(fromType)), isStruct(indirectType(toType))
+	switch {
+	case fromMap && toStruct && !toSlice, fromStruct && !fromSlice && toMap,
+		toSlice && (fromSlice || fromArray) && !fromStruct:
+		return fmt.Errorf("copying %s into %s needs reflection", g.typeString(fromType), g.typeString(toType))
+	case !fromStruct || !toStruct:
+		// Copy leaves values that are not structs as they are when they do not convert
+		return nil
+	case fromSlice && toSlice:
+		return g.copySlice(to, toType, from, fromType)
+	case fromSlice || toSlice:
+		return fmt.Errorf("copying %s into %s needs reflection", g.typeString(fromType), g.typeString(toType))
+	}
+
+	fn, ok := g.pairFunc(fromType, toType)
+	if !ok {
+		return fmt.Errorf("copying %s into %s needs a %s %s %s annotation",
+			g.typeString(fromType), g.typeString(toType), annotation, g.typeString(fromType), g.typeString(toType))
+	}
+	fmt.Fprintf(&g.body, "if err := %s(%s, %s); err != nil {\nreturn err\n}\n", fn, address(to), address(from))
+	return nil
+}
+
+// copySlice emits the copy of a slice of structs, appending the copies of the elements like Copy does
+func (g *generator) copySlice(to string, toType types.Type, from string, fromType types.Type) error {
+	fromElem, toElem := fromType.Underlying().(*types.Slice).Elem(), toType.Underlying().(*types.Slice).Elem()
+	fromStruct, fromPointer := fromElem, false
+	if pointer, ok := fromElem.(*types.Pointer); ok {
+		fromStruct, fromPointer = pointer.Elem(), true
+	}
+	toStruct, toPointer := toElem, false
+	if pointer, ok := toElem.(*types.Pointer); ok {
+		toStruct, toPointer = pointer.Elem(), true
+	}
+	fn, ok := g.pairFunc(fromStruct, toStruct)
+	if !ok {
+		return fmt.Errorf("copying %s into %s needs a %s %s %s annotation", g.typeString(fromType), g.typeString(toType),
+			annotation, g.typeString(fromStruct), g.typeString(toStruct))
+	}
+
+	element := "&" + from + "[i]"
+	if fromPointer {
+		element = from + "[i]"
+	}
+	fmt.Fprintf(&g.body, "for i := range %s {\nvar elem %s\n", from, g.typeString(toStruct))
+	if fromPointer {
+		fmt.Fprintf(&g.body, "if %s != nil {\n", element)
+	}
+	fmt.Fprintf(&g.body, "if err := %s(&elem, %s); err != nil {\nreturn err\n}\n", fn, element)
+	if fromPointer {
+		fmt.Fprintf(&g.body, "}\n")
+	}
+	if toPointer {
+		fmt.Fprintf(&g.body, "%s = append(%s, &elem)\n}\n", to, to)
+	} else {
+		fmt.Fprintf(&g.body, "%s = append(%s, elem)\n}\n", to, to)
+	}
+	return nil
+}
+
+// selector parenthesizes a dereference to select a method or field of it
+func selector(expr string) string {
+	if strings.HasPrefix(expr, "*") {
+		return "(" + expr + ")"
+	}
+	return expr
+}
+
+// address takes the address of an addressable expression
+func address(expr string) string {
+	if strings.HasPrefix(expr, "*") {
+		return expr[1:]
+	}
+	return "&" + expr
+}
+
+// returnsNothingOrError reports whether the signature returns that many values, optionally followed by an error
+func returnsNothingOrError(signature *types.Signature, values int) bool {
+	switch signature.Results().Len() {
+	case values:
+		return true
+	case values + 1:
+		return isError(signature.Results().At(values).Type())
+	}
+	return false
+}
+
+func isError(t types.Type) bool {
+	return types.Identical(t, types.Universe.Lookup("error").Type())
+}
+
+// isScanner reports whether a pointer to the type implements sql.Scanner
+func isScanner(t types.Type) bool {
+	object, _, _ := types.LookupFieldOrMethod(types.NewPointer(t), false, nil, "Scan")
+	method, ok := object.(*types.Func)
+	if !ok {
+		return false
+	}
+	signature := method.Type().(*types.Signature)
+	if signature.Params().Len() != 1 || !returnsNothingOrError(signature, 0) || signature.Results().Len() != 1 {
+		return false
+	}
+	param, ok := signature.Params().At(0).Type().Underlying().(*types.Interface)
+	return ok && param.Empty()
+}
+
+// isValuer reports whether the type, or a pointer to it, implements driver.Valuer
+func isValuer(t types.Type) bool {
+	object, _, _ := types.LookupFieldOrMethod(t, true, nil, "Value")
+	method, ok := object.(*types.Func)
+	if !ok {
+		return false
+	}
+	signature := method.Type().(*types.Signature)
+	if signature.Params().Len() != 0 || signature.Results().Len() != 2 || !isError(signature.Results().At(1).Type()) {
+		return false
+	}
+	value, ok := signature.Results().At(0).Type().(*types.Named)
+	return ok && value.Obj().Pkg() != nil && value.Obj().Pkg().Path() == "database/sql/driver" && value.Obj().Name() == "Value"
+}
+
+func isInteger(t types.Type) bool {
+	basic, ok := t.Underlying().(*types.Basic)
+	return ok && basic.Info()&types.IsInteger != 0
+}
+
+func isString(t types.Type) bool {
+	basic, ok := t.Underlying().(*types.Basic)
+	return ok && basic.Info()&types.IsString != 0
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerate_Example(t *testing.T) {
+	generated, err := generate("internal/example", "copiergen.go")
+	if !assert.NoError(t, err) {
+		return
+	}
+	committed, err := os.ReadFile("internal/example/copiergen.go")
+	if assert.NoError(t, err) {
+		assert.Equal(t, string(committed), string(generated), "run go generate ./cmd/copiergen/internal/example")
+	}
+}
+
+func writePackage(t *testing.T, source string) string {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "types.go"), []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestGenerate_Errors(t *testing.T) {
+	tests := map[string]struct {
+		source string
+		err    string
+	}{
+		"no annotations": {
+			source: "package p\n\ntype A struct{}\n",
+			err:    "no //copiergen:copy annotations in ",
+		},
+		"unknown type": {
+			source: "package p\n\n//copiergen:copy A B\ntype A struct{}\n",
+			err:    "B is not a struct type of package p",
+		},
+		"nested pair": {
+			source: "package p\n\n//copiergen:copy A B\ntype A struct{ N struct{ X int } }\ntype B struct{ N C }\ntype C struct{ X string }\n",
+			err:    "CopyAToB: copying A.N into B.N: copying struct{X int} into C needs a //copiergen:copy struct{X int} C annotation",
+		},
+		"valuer": {
+			source: "package p\n\nimport \"database/sql\"\n\n//copiergen:copy A B\ntype A struct{ N sql.NullInt64 }\ntype B struct{ N int }\n",
+			err:    "CopyAToB: copying A.N into B.N: copying the driver.Valuer sql.NullInt64 needs reflection",
+		},
+		"map": {
+			source: "package p\n\n//copiergen:copy A B\ntype A struct{ N map[string]int }\ntype B struct{ N struct{ X int } }\n",
+			err:    "CopyAToB: copying A.N into B.N: copying map[string]int into struct{X int} needs reflection",
+		},
+		"must": {
+			source: "package p\n\n//copiergen:copy A B\ntype A struct{}\ntype B struct{ N int `copier:\"must\"` }\n",
+			err:    `CopyAToB: field B.N is tagged must but A has no field or method "N" to copy from`,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := generate(writePackage(t, test.source), "copiergen.go")
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+}
+
+func TestGenerate_Setter(t *testing.T) {
+	dir := writePackage(t, `package p
+
+//copiergen:copy A B copyA
+type A struct {
+	Name  string
+	Email string
+}
+
+type B struct {
+	name, email string
+}
+
+func (b *B) Name(name string) { b.name = name }
+
+func (b *B) SetEmail(email string) error { b.email = email; return nil }
+`)
+	generated, err := generate(dir, "copiergen.go")
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(generated), "\tto.Name(from.Name)\n")
+		assert.Contains(t, string(generated), "\tif err := to.SetEmail(from.Email); err != nil {\n")
+	}
+}
+
+func TestGenerate_ShadowedFields(t *testing.T) {
+	dir := writePackage(t, `package p
+
+type Base struct {
+	ID   int
+	Name string
+}
+
+//copiergen:copy Derived Out
+type Derived struct {
+	Base
+	ID int
+}
+
+type Out struct {
+	ID   int
+	Name string
+}
+`)
+	generated, err := generate(dir, "copiergen.go")
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(generated), "\tto.ID = from.ID\n")
+		assert.Contains(t, string(generated), "\tto.Name = from.Name\n")
+		assert.NotContains(t, string(generated), "from.Base.ID")
+	}
+}
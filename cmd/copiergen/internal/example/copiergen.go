@@ -0,0 +1,108 @@
+// Code generated by copiergen. DO NOT EDIT.
+
+package example
+
+import (
+	"time"
+)
+
+// CopyUserToUserDTO copies User into UserDTO like utils.Copy does, without reflection
+func CopyUserToUserDTO(to *UserDTO, from *User) error {
+	if from == nil {
+		return nil
+	}
+	if to.DTOAudit == nil {
+		to.DTOAudit = new(DTOAudit)
+	}
+	if to.Created == nil {
+		to.Created = new(time.Time)
+	}
+	*to.Created = from.Created
+	if to.DTOAudit == nil {
+		to.DTOAudit = new(DTOAudit)
+	}
+	to.Version = int64(from.Version)
+	to.ID = int(from.ID)
+	to.UserName = from.Login
+	if to.FirstName == nil {
+		to.FirstName = new(string)
+	}
+	*to.FirstName = from.FirstName
+	to.LastName = from.LastName
+	if from.Nickname != nil {
+		to.Nickname = *from.Nickname
+	}
+	to.Status = string(from.Status)
+	to.Age = int64(from.Age)
+	_ = to.Note.Scan(from.Note)
+	if err := to.SetPhone(from.Phone); err != nil {
+		return err
+	}
+	if from.Address == nil {
+		to.Address = nil
+	} else {
+		if to.Address == nil {
+			to.Address = new(AddressDTO)
+		}
+		if err := CopyAddressToAddressDTO(to.Address, from.Address); err != nil {
+			return err
+		}
+	}
+	if err := CopyAddressToAddressDTO(&to.Previous, &from.Previous); err != nil {
+		return err
+	}
+	for i := range from.Emails {
+		var elem EmailDTO
+		if err := copyEmail(&elem, &from.Emails[i]); err != nil {
+			return err
+		}
+		to.Emails = append(to.Emails, elem)
+	}
+	for i := range from.Others {
+		var elem EmailDTO
+		if from.Others[i] != nil {
+			if err := copyEmail(&elem, from.Others[i]); err != nil {
+				return err
+			}
+		}
+		to.Others = append(to.Others, &elem)
+	}
+	to.Tags = from.Tags
+	{
+		value := from.DisplayName()
+		to.DisplayName = value
+	}
+	{
+		value, err := from.Initials()
+		if err != nil {
+			return err
+		}
+		to.Initials = value
+	}
+	return nil
+}
+
+// CopyAddressToAddressDTO copies Address into AddressDTO like utils.Copy does, without reflection
+func CopyAddressToAddressDTO(to *AddressDTO, from *Address) error {
+	if from == nil {
+		return nil
+	}
+	to.Street = from.Street
+	if to.City == nil {
+		to.City = new(string)
+	}
+	*to.City = from.City
+	return nil
+}
+
+// copyEmail copies Email into EmailDTO like utils.Copy does, without reflection
+func copyEmail(to *EmailDTO, from *Email) error {
+	if from == nil {
+		return nil
+	}
+	to.Value = from.Value
+	if from.Primary != nil {
+		to.Primary = *from.Primary
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Package example holds the types copied by the functions generated by copiergen, to compare them with utils.Copy
+package example
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+//go:generate go run github.com/putchi/go-lib-utils/cmd/copiergen
+
+//copiergen:copy User UserDTO
+//copiergen:copy Address AddressDTO
+//copiergen:copy Email EmailDTO copyEmail
+
+// Status of a user
+type Status string
+
+// Audit fields shared by entities
+type Audit struct {
+	Created time.Time
+	Version int
+}
+
+// Address of a user
+type Address struct {
+	Street string
+	City   string
+}
+
+// AddressDTO is the copy of an Address
+type AddressDTO struct {
+	Street string
+	City   *string
+}
+
+// Email of a user
+type Email struct {
+	Value   string
+	Primary *bool
+}
+
+// EmailDTO is the copy of an Email
+type EmailDTO struct {
+	Value   string
+	Primary bool
+}
+
+// User is the entity copied from
+type User struct {
+	Audit
+	ID        int64
+	Login     string `copier:"name=UserName"`
+	FirstName string
+	LastName  string
+	Nickname  *string
+	Status    Status
+	Age       int32
+	Password  string `copier:"-"`
+	Note      string
+	Phone     string
+	Address   *Address
+	Previous  Address
+	Emails    []Email
+	Others    []*Email
+	Tags      []string
+	Manager   *User `copier:"-"`
+	internal  string
+}
+
+// DisplayName is copied into UserDTO.DisplayName
+func (u User) DisplayName() string {
+	return strings.TrimSpace(u.FirstName + " " + u.LastName)
+}
+
+// Initials is copied into UserDTO.Initials, failing without a name
+func (u *User) Initials() (string, error) {
+	if u.FirstName == "" || u.LastName == "" {
+		return "", errors.New("no name")
+	}
+	return u.FirstName[:1] + u.LastName[:1], nil
+}
+
+// DTOAudit is embedded into UserDTO through a pointer
+type DTOAudit struct {
+	Created *time.Time
+	Version int64
+}
+
+// UserDTO is the copy of a User
+type UserDTO struct {
+	*DTOAudit
+	ID          int
+	UserName    string
+	FirstName   *string
+	LastName    string
+	Nickname    string
+	Status      string
+	Age         int64
+	Password    string
+	Note        sql.NullString
+	Address     *AddressDTO
+	Previous    AddressDTO
+	Emails      []EmailDTO
+	Others      []*EmailDTO
+	Tags        []string
+	DisplayName string
+	Initials    string `copier:"must"`
+	Ignored     string `copier:"-"`
+
+	nickname string
+	phone    string
+}
+
+// SetNote is not called, User.Note is copied into UserDTO.Note directly
+func (u *UserDTO) SetNote(note string) {
+	u.nickname = note
+}
+
+// SetPhone is called with User.Phone, UserDTO has no such field
+func (u *UserDTO) SetPhone(phone string) error {
+	if strings.ContainsAny(phone, "abc") {
+		return errors.New("invalid phone")
+	}
+	u.phone = phone
+	return nil
+}
@@ -0,0 +1,138 @@
+package example
+
+import (
+	"testing"
+	"time"
+
+	utils "github.com/putchi/go-lib-utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func newUser() User {
+	primary := true
+	return User{
+		Audit:     Audit{Created: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Version: 3},
+		ID:        42,
+		Login:     "jdoe",
+		FirstName: "John",
+		LastName:  "Doe",
+		Nickname:  utils.StringPtr("Johnny"),
+		Status:    "active",
+		Age:       33,
+		Password:  "secret",
+		Note:      "note",
+		Phone:     "555-0100",
+		Address:   &Address{Street: "Main St", City: "Springfield"},
+		Previous:  Address{City: "Shelbyville"},
+		Emails:    []Email{{Value: "jdoe@example.com", Primary: &primary}, {Value: "john@example.com"}},
+		Others:    []*Email{{Value: "doe@example.com"}, nil},
+		Tags:      []string{"admin"},
+		Manager:   &User{Login: "boss"},
+		internal:  "internal",
+	}
+}
+
+func TestCopyUserToUserDTO(t *testing.T) {
+	tests := map[string]func(*User){
+		"all fields":   func(*User) {},
+		"nil pointers": func(u *User) { u.Nickname, u.Address, u.Emails[0].Primary, u.Others = nil, nil, nil, nil },
+		"zero values":  func(u *User) { *u = User{FirstName: "J", LastName: "D"} },
+	}
+	for name, modify := range tests {
+		t.Run(name, func(t *testing.T) {
+			user := newUser()
+			modify(&user)
+
+			var generated, reflected UserDTO
+			assert.NoError(t, CopyUserToUserDTO(&generated, &user))
+			assert.NoError(t, utils.Copy(&reflected, &user))
+			assert.Equal(t, reflected, generated)
+		})
+	}
+}
+
+func TestCopyUserToUserDTO_Existing(t *testing.T) {
+	user := newUser()
+	existing := func() UserDTO {
+		return UserDTO{
+			DTOAudit: &DTOAudit{Version: 1},
+			Password: "kept",
+			Address:  &AddressDTO{Street: "Old St"},
+			Emails:   []EmailDTO{{Value: "old@example.com"}},
+			Ignored:  "kept",
+		}
+	}
+
+	generated, reflected := existing(), existing()
+	assert.NoError(t, CopyUserToUserDTO(&generated, &user))
+	assert.NoError(t, utils.Copy(&reflected, &user))
+	assert.Equal(t, reflected, generated)
+	assert.Len(t, generated.Emails, 3)
+}
+
+func TestCopyUserToUserDTO_Errors(t *testing.T) {
+	user := newUser()
+	user.LastName = ""
+
+	var generated, reflected UserDTO
+	generatedErr := CopyUserToUserDTO(&generated, &user)
+	reflectedErr := utils.Copy(&reflected, &user)
+	assert.EqualError(t, generatedErr, "no name")
+	assert.EqualError(t, reflectedErr, "no name")
+	assert.Equal(t, reflected, generated)
+}
+
+func TestCopyUserToUserDTO_SetterError(t *testing.T) {
+	user := newUser()
+	user.Phone = "call me"
+
+	var generated, reflected UserDTO
+	assert.EqualError(t, CopyUserToUserDTO(&generated, &user), "invalid phone")
+	assert.EqualError(t, utils.Copy(&reflected, &user), "invalid phone")
+	assert.Equal(t, reflected, generated)
+}
+
+func TestCopyAddressToAddressDTO(t *testing.T) {
+	var generated, reflected AddressDTO
+	assert.NoError(t, CopyAddressToAddressDTO(&generated, nil))
+	assert.NoError(t, utils.Copy(&reflected, (*Address)(nil)))
+	assert.Equal(t, reflected, generated)
+}
+
+func BenchmarkCopyUserToUserDTO(b *testing.B) {
+	user := newUser()
+	b.Run("generated", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var dto UserDTO
+			_ = CopyUserToUserDTO(&dto, &user)
+		}
+	})
+	b.Run("reflection", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var dto UserDTO
+			_ = utils.Copy(&dto, &user)
+		}
+	})
+}
+
+func TestCopyUserToUserDTO_Values(t *testing.T) {
+	user := newUser()
+
+	var dto UserDTO
+	if !assert.NoError(t, CopyUserToUserDTO(&dto, &user)) {
+		return
+	}
+	assert.Equal(t, "jdoe", dto.UserName)
+	assert.Equal(t, "John", *dto.FirstName)
+	assert.Equal(t, "Johnny", dto.Nickname)
+	assert.Equal(t, int64(3), dto.Version)
+	assert.True(t, dto.Note.Valid)
+	assert.Equal(t, "Springfield", *dto.Address.City)
+	assert.Equal(t, []EmailDTO{{Value: "jdoe@example.com", Primary: true}, {Value: "john@example.com"}}, dto.Emails)
+	assert.Equal(t, []*EmailDTO{{Value: "doe@example.com"}, {}}, dto.Others)
+	assert.Equal(t, "John Doe", dto.DisplayName)
+	assert.Equal(t, "JD", dto.Initials)
+	assert.Empty(t, dto.Password)
+	assert.Equal(t, "555-0100", dto.phone)
+	assert.Empty(t, dto.nickname)
+}
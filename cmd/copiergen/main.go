@@ -0,0 +1,42 @@
+// Command copiergen generates copy functions that follow the rules of utils.Copy without reflection.
+//
+// Annotate the pairs of struct types of a package to copy from and into, optionally naming the function:
+//
+//	//copiergen:copy User UserDTO
+//	//copiergen:copy Address AddressDTO copyAddress
+//
+// and generate the functions with
+//
+//	//go:generate go run github.com/putchi/go-lib-utils/cmd/copiergen
+//
+// Every pair gets a func CopyUserToUserDTO(to *UserDTO, from *User) error copying fields by name,
+// copier tags, setter methods, methods into fields, sql.Scanner destinations and pointers like Copy
+// with the default CopyOption. Nested structs and slices of structs are copied by the functions of their pairs.
+// Copies that need reflection, e.g. from driver.Valuer sources or between maps and structs, fail the
+// generation, and shared or cyclic pointers are copied over rather than reproduced.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	output := flag.String("output", "copiergen.go", "name of the generated file in the package directory")
+	flag.Parse()
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	source, err := generate(dir, *output)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, *output), source, 0o644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "copiergen:", err)
+		os.Exit(1)
+	}
+}
@@ -2,12 +2,48 @@ package utils
 
 import (
 	"database/sql"
+	"database/sql/driver"
 	"errors"
+	"fmt"
 	"reflect"
+	"time"
+
+	"github.com/putchi/go-lib-utils/internal/copierfields"
 )
 
+// parseCopierTag parses the `copier:"..."` struct tag of the field
+func parseCopierTag(field reflect.StructField) copierfields.Tag {
+	return copierfields.ParseTag(field.Tag)
+}
+
 // Copy copy things
 func Copy(toValue interface{}, fromValue interface{}) (err error) {
+	return CopyWithOption(toValue, fromValue, CopyOption{})
+}
+
+// CopyWithOption copy things, customized by opt
+func CopyWithOption(toValue interface{}, fromValue interface{}, opt CopyOption) (err error) {
+	if len(opt.FieldMask) > 0 {
+		if opt.mask, err = compileFieldMask(opt.FieldMask, reflect.TypeOf(fromValue)); err != nil {
+			return err
+		}
+		opt.FieldMask = nil
+	}
+	if opt.Strict && opt.collected == nil {
+		// collect the problems of the whole copy
+		opt.collected = &CopyErrors{}
+		_ = opt.fail(CopyWithOption(toValue, fromValue, opt))
+		if len(*opt.collected) > 0 {
+			return *opt.collected
+		}
+		return nil
+	}
+
+	if opt.visits == nil {
+		opt.visits = copyVisits{}
+		opt.visits.visitRoot(reflect.ValueOf(toValue), reflect.ValueOf(fromValue))
+	}
+
 	var (
 		isSlice bool
 		amount  = 1
@@ -24,17 +60,44 @@ func Copy(toValue interface{}, fromValue interface{}) (err error) {
 		return
 	}
 
+	if converted, err2 := convert(to, from, opt); converted || err2 != nil {
+		return err2
+	}
+	if unwrapped, err2 := unwrapValuer(to, from, opt); unwrapped || err2 != nil {
+		return err2
+	}
+
+	switch {
+	case opt.SemanticConversions && isSemanticKind(from.Kind()) && isSemanticKind(to.Kind()) && from.Kind() != to.Kind():
+		return convertSemantic(to, from)
+	case from.Kind() == reflect.Map && to.Kind() == reflect.Struct:
+		return copyMapToStruct(to, from, opt)
+	case from.Kind() == reflect.Struct && to.Kind() == reflect.Map:
+		return copyStructToMap(to, from, opt)
+	case to.Kind() == reflect.Slice && isElementwiseSlice(from, to):
+		return copySliceElements(to, from, opt)
+	}
+
 	fromType := IndirectType(from.Type())
 	toType := IndirectType(to.Type())
 
 	// Just set it if possible to assign
 	// And need to do copy anyway if the type is struct
-	done := cleanSet(fromType, from, to, toType)
+	done := cleanSet(fromType, from, to, toType, opt)
 	if done {
+		if opt.Strict && !assigned(fromType, from, to) {
+			return fmt.Errorf("cannot copy %s into %s", from.Type(), to.Type())
+		}
 		return
 	}
 
 	isSlice, amount = sliceInfo(to, isSlice, from, amount)
+	plan := copyPlanFor(fromType, toType, opt)
+	// elements of the type copied into are copied in place, others are appended when done
+	inPlace := isSlice && to.Type().Elem() == toType
+	if inPlace {
+		to.Grow(amount)
+	}
 
 	for i := 0; i < amount; i++ {
 		var dest, source reflect.Value
@@ -43,7 +106,12 @@ func Copy(toValue interface{}, fromValue interface{}) (err error) {
 			// source
 			source = setSource(from, source, i)
 			// dest
-			dest = Indirect(reflect.New(toType).Elem())
+			if inPlace {
+				to.SetLen(to.Len() + 1)
+				dest = to.Index(to.Len() - 1)
+			} else {
+				dest = Indirect(reflect.New(toType).Elem())
+			}
 		} else {
 			source = Indirect(from)
 			dest = Indirect(to)
@@ -51,18 +119,20 @@ func Copy(toValue interface{}, fromValue interface{}) (err error) {
 
 		// check source
 		if source.IsValid() {
-			fromTypeFields := deepFields(fromType)
-			//fmt.Printf("%#v", fromTypeFields)
-			// Copy from field to field or method
-			err2 := copyFieldToFieldOrMethod(fromTypeFields, source, dest)
-			if err2 != nil {
+			elemOpt := opt
+			if isSlice && from.Kind() == reflect.Slice {
+				elemOpt = opt.index(i)
+			}
+			if err2 := copyStruct(dest, source, plan, elemOpt); err2 != nil {
+				if inPlace {
+					to.SetLen(to.Len() - 1)
+				}
 				return err2
 			}
-
-			// Copy from method to field
-			copyMethodToField(toType, source, dest)
 		}
-		sliceWorker(isSlice, dest, to)
+		if !inPlace {
+			sliceWorker(isSlice, dest, to)
+		}
 	}
 	return
 }
@@ -86,8 +156,16 @@ func sliceInfo(to reflect.Value, isSlice bool, from reflect.Value, amount int) (
 	return isSlice, amount
 }
 
-func cleanSet(fromType reflect.Type, from reflect.Value, to reflect.Value, toType reflect.Type) bool {
+// assigned reports whether cleanSet assigned the value rather than giving up on it
+func assigned(fromType reflect.Type, from reflect.Value, to reflect.Value) bool {
+	return fromType.Kind() != reflect.Struct && from.Type().AssignableTo(to.Type())
+}
+
+func cleanSet(fromType reflect.Type, from reflect.Value, to reflect.Value, toType reflect.Type, opt CopyOption) bool {
 	if fromType.Kind() != reflect.Struct && from.Type().AssignableTo(to.Type()) {
+		if opt.DeepCopy {
+			from = deepClone(from, opt.visits)
+		}
 		to.Set(from)
 		return true
 	}
@@ -98,30 +176,25 @@ func cleanSet(fromType reflect.Type, from reflect.Value, to reflect.Value, toTyp
 	return false
 }
 
-func copyFieldToFieldOrMethod(fromTypeFields []reflect.StructField, source reflect.Value, dest reflect.Value) error {
-	for _, field := range fromTypeFields {
-		name := field.Name
-
-		if fromField := source.FieldByName(name); fromField.IsValid() {
-			// has field
-			if toField := dest.FieldByName(name); toField.IsValid() {
-				err2 := setWithField(toField, fromField)
-				if err2 != nil {
-					return err2
-				}
-			} else {
-				// try to set to method
-				setToMethod(dest, name, fromField)
-			}
-		}
+func setWithField(toField reflect.Value, fromField reflect.Value, opt CopyOption) error {
+	if toField.CanSet() && opt.mask != nil {
+		return copyMasked(toField, fromField, opt)
 	}
-	return nil
-}
-
-func setWithField(toField reflect.Value, fromField reflect.Value) error {
 	if toField.CanSet() {
-		if !set(toField, fromField) {
-			if err := Copy(toField.Addr().Interface(), fromField.Interface()); err != nil {
+		if opt.patching() && mergeableStructs(toField, fromField) {
+			// merge field by field, so the policies apply to nested structs as well
+			if toField.Kind() == reflect.Ptr && opt.visits.visit(toField, fromField) {
+				return nil
+			}
+			return CopyWithOption(toField.Addr().Interface(), fromField.Interface(), opt)
+		}
+		if opt.Overwrite == OverwriteEmpty && !toField.IsZero() {
+			return nil
+		}
+		if ok, err := set(toField, fromField, opt); err != nil {
+			return err
+		} else if !ok {
+			if err := CopyWithOption(toField.Addr().Interface(), fromField.Interface(), opt); err != nil {
 				return err
 			}
 		}
@@ -129,39 +202,26 @@ func setWithField(toField reflect.Value, fromField reflect.Value) error {
 	return nil
 }
 
-func setToMethod(dest reflect.Value, name string, fromField reflect.Value) {
-	var toMethod reflect.Value
-	if dest.CanAddr() {
-		toMethod = dest.Addr().MethodByName(name)
-	} else {
-		toMethod = dest.MethodByName(name)
+// mergeableStructs reports whether both fields hold structs with exported fields to merge
+func mergeableStructs(toField reflect.Value, fromField reflect.Value) bool {
+	from := Indirect(fromField)
+	if !from.IsValid() || from.Kind() != reflect.Struct || !hasExportedFields(from.Type()) {
+		return false
 	}
-
-	if toMethod.IsValid() && toMethod.Type().NumIn() == 1 && fromField.Type().AssignableTo(toMethod.Type().In(0)) {
-		toMethod.Call([]reflect.Value{fromField})
+	toType := toField.Type()
+	for toType.Kind() == reflect.Ptr {
+		toType = toType.Elem()
 	}
+	return toType.Kind() == reflect.Struct && hasExportedFields(toType)
 }
 
-func copyMethodToField(toType reflect.Type, source reflect.Value, dest reflect.Value) {
-	for _, field := range deepFields(toType) {
-		name := field.Name
-
-		var fromMethod reflect.Value
-		if source.CanAddr() {
-			fromMethod = source.Addr().MethodByName(name)
-		} else {
-			fromMethod = source.MethodByName(name)
-		}
-
-		if fromMethod.IsValid() && fromMethod.Type().NumIn() == 0 && fromMethod.Type().NumOut() == 1 {
-			if toField := dest.FieldByName(name); toField.IsValid() && toField.CanSet() {
-				values := fromMethod.Call([]reflect.Value{})
-				if len(values) >= 1 {
-					set(toField, values[0])
-				}
-			}
+func hasExportedFields(structType reflect.Type) bool {
+	for _, field := range deepFields(structType) {
+		if field.IsExported() {
+			return true
 		}
 	}
+	return false
 }
 
 func sliceWorker(isSlice bool, dest reflect.Value, to reflect.Value) {
@@ -175,55 +235,240 @@ func sliceWorker(isSlice bool, dest reflect.Value, to reflect.Value) {
 }
 
 func deepFields(reflectType reflect.Type) []reflect.StructField {
-	var fields []reflect.StructField
+	return copierfields.Deep(reflectType, structFields, func(field reflect.StructField) (reflect.Type, bool) {
+		return field.Type, field.Anonymous
+	})
+}
 
+// structFields lists the direct fields of a struct, or of the struct a pointer or slice points to
+func structFields(reflectType reflect.Type) []reflect.StructField {
+	var fields []reflect.StructField
 	if reflectType = IndirectType(reflectType); reflectType.Kind() == reflect.Struct {
 		for i := 0; i < reflectType.NumField(); i++ {
-			v := reflectType.Field(i)
-			if v.Anonymous {
-				fields = append(fields, deepFields(v.Type)...)
-			} else {
-				fields = append(fields, v)
-			}
+			fields = append(fields, reflectType.Field(i))
 		}
 	}
+	return fields
+}
 
+// visibleFields returns the fields of the struct type reachable by name, like FieldByName: a field
+// shadows the deeper fields of the same name, and fields ambiguous at the same depth are left out
+func visibleFields(reflectType reflect.Type) []reflect.StructField {
+	fields := structFields(reflectType)
+	for _, field := range fields {
+		if field.Anonymous {
+			return copierfields.Visible(deepFields(reflectType), func(field reflect.StructField) string {
+				return field.Name
+			}, IndirectType(reflectType).FieldByName)
+		}
+	}
+	// without embedded fields every field is reachable by its own name
 	return fields
 }
 
-func set(to, from reflect.Value) bool {
+func set(to, from reflect.Value, opt CopyOption) (bool, error) {
 	if from.IsValid() {
+		if converted, err := convert(to, from, opt); converted || err != nil {
+			return true, err
+		}
+		if unwrapped, err := unwrapValuer(to, from, opt); unwrapped || err != nil {
+			return true, err
+		}
 		if to.Kind() == reflect.Ptr {
 			//set `to` to nil if from is nil
 			if from.Kind() == reflect.Ptr && from.IsNil() {
 				to.Set(reflect.Zero(to.Type()))
-				return true
-			} else if to.IsNil() {
-				to.Set(reflect.New(to.Type().Elem()))
+				return true, nil
+			} else if opt.visits.visit(to, from) {
+				return true, nil
 			}
 			to = to.Elem()
+			if converted, err := convert(to, from, opt); converted || err != nil {
+				return true, err
+			}
 		}
 
-		b, done := setStep2(to, from)
+		b, done, err := setStep2(to, from, opt)
 		if done {
-			return b
+			return b, err
 		}
 	}
-	return true
+	return true, nil
 }
 
-func setStep2(to reflect.Value, from reflect.Value) (bool, bool) {
+// unwrapValuer copies the value of a driver.Valuer source such as sql.NullInt64,
+// setting pointers to nil and other fields to their zero value for NULL.
+// Only destinations holding a database value are set this way, others are copied field by field.
+func unwrapValuer(to reflect.Value, from reflect.Value, opt CopyOption) (bool, error) {
+	if !from.CanInterface() || (from.Kind() == reflect.Ptr && from.IsNil()) {
+		return false, nil
+	}
+	toType := to.Type()
+	if from.Type().ConvertibleTo(toType) || (toType.Kind() == reflect.Ptr && from.Type().ConvertibleTo(toType.Elem())) ||
+		!isDatabaseValueType(toType) {
+		return false, nil
+	}
+	valuer, ok := from.Interface().(driver.Valuer)
+	if !ok && from.CanAddr() {
+		valuer, ok = from.Addr().Interface().(driver.Valuer)
+	}
+	if !ok {
+		return false, nil
+	}
+
+	value, err := valuer.Value()
+	if err != nil {
+		return true, err
+	}
+	if value == nil {
+		to.Set(reflect.Zero(toType))
+		return true, nil
+	}
+	unwrapped := reflect.ValueOf(value)
+	if opt.DeepCopy {
+		unwrapped = deepClone(unwrapped, opt.visits)
+	}
+	// a value the destination cannot take, e.g. the JSON of a struct, leaves it to the field by field copy
+	return set(to, unwrapped, opt)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// isDatabaseValueType reports whether the type, or the type it points to, holds a driver.Value:
+// a basic kind, []byte, time.Time, or a sql.Scanner
+func isDatabaseValueType(reflectType reflect.Type) bool {
+	reflectType = IndirectType(reflectType)
+	if reflect.PtrTo(reflectType).Implements(scannerType) || reflectType == timeType {
+		return true
+	}
+	kind := reflectType.Kind()
+	return isSemanticKind(kind) || (kind == reflect.Slice && reflectType.Elem().Kind() == reflect.Uint8)
+}
+
+func setStep2(to reflect.Value, from reflect.Value, opt CopyOption) (bool, bool, error) {
+	if opt.SemanticConversions && isSemanticKind(from.Kind()) && isSemanticKind(to.Kind()) && from.Kind() != to.Kind() {
+		return true, true, convertSemantic(to, from)
+	}
 	if from.Type().ConvertibleTo(to.Type()) {
+		if opt.DeepCopy {
+			from = deepClone(from, opt.visits)
+		}
 		to.Set(from.Convert(to.Type()))
 	} else if scanner, ok := to.Addr().Interface().(sql.Scanner); ok {
 		err := scanner.Scan(from.Interface())
 		if err != nil {
-			return false, true
+			if opt.Strict {
+				return false, true, err
+			}
+			return false, true, nil
 		}
 	} else if from.Kind() == reflect.Ptr {
-		return set(to, from.Elem()), true
+		b, err := set(to, from.Elem(), opt)
+		return b, true, err
 	} else {
-		return false, true
+		return false, true, nil
+	}
+	return false, false, nil
+}
+
+// copyVisits maps the source pointers copied so far to their copies, so that cycles and
+// shared references in the source are reproduced instead of copied over and over
+type copyVisits map[visitKey]reflect.Value
+
+type visitKey struct {
+	pointer uintptr
+	from    reflect.Type
+	to      reflect.Type
+}
+
+// visit points the nil or non-nil pointer to at the copy of from when there is one already, reporting whether
+// it did. Otherwise to is allocated when nil, and recorded as the copy of a non-nil pointer from.
+func (visits copyVisits) visit(to reflect.Value, from reflect.Value) bool {
+	if from.Kind() != reflect.Ptr || from.IsNil() || visits == nil {
+		if to.IsNil() {
+			to.Set(reflect.New(to.Type().Elem()))
+		}
+		return false
+	}
+	key := visitKey{from.Pointer(), from.Type(), to.Type()}
+	if copied, ok := visits[key]; ok {
+		to.Set(copied)
+		return true
+	}
+	if to.IsNil() {
+		to.Set(reflect.New(to.Type().Elem()))
+	}
+	visits[key] = to.Elem().Addr()
+	return false
+}
+
+// visitRoot records the pointer copied into as the copy of the pointer copied from
+func (visits copyVisits) visitRoot(to reflect.Value, from reflect.Value) {
+	if to.Kind() == reflect.Ptr && !to.IsNil() && from.Kind() == reflect.Ptr && !from.IsNil() {
+		visits[visitKey{from.Pointer(), from.Type(), to.Type()}] = to
+	}
+}
+
+// deepClone returns a copy of value sharing no maps, slices, arrays or pointers with it.
+// Pointers already cloned or copied in visits are reused, so cyclic and shared references are kept.
+// Unexported struct fields, channels and functions are copied as is.
+func deepClone(value reflect.Value, visits copyVisits) reflect.Value {
+	if visits == nil {
+		visits = copyVisits{}
+	}
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			return reflect.Zero(value.Type())
+		}
+		key := visitKey{value.Pointer(), value.Type(), value.Type()}
+		if clone, ok := visits[key]; ok {
+			return clone
+		}
+		clone := reflect.New(value.Type().Elem())
+		visits[key] = clone
+		clone.Elem().Set(deepClone(value.Elem(), visits))
+		return clone
+	case reflect.Interface:
+		if value.IsNil() {
+			return reflect.Zero(value.Type())
+		}
+		clone := reflect.New(value.Type()).Elem()
+		clone.Set(deepClone(value.Elem(), visits))
+		return clone
+	case reflect.Map:
+		if value.IsNil() {
+			return reflect.Zero(value.Type())
+		}
+		clone := reflect.MakeMapWithSize(value.Type(), value.Len())
+		for iter := value.MapRange(); iter.Next(); {
+			clone.SetMapIndex(deepClone(iter.Key(), visits), deepClone(iter.Value(), visits))
+		}
+		return clone
+	case reflect.Slice:
+		if value.IsNil() {
+			return reflect.Zero(value.Type())
+		}
+		clone := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		for i := 0; i < value.Len(); i++ {
+			clone.Index(i).Set(deepClone(value.Index(i), visits))
+		}
+		return clone
+	case reflect.Array:
+		clone := reflect.New(value.Type()).Elem()
+		for i := 0; i < value.Len(); i++ {
+			clone.Index(i).Set(deepClone(value.Index(i), visits))
+		}
+		return clone
+	case reflect.Struct:
+		clone := reflect.New(value.Type()).Elem()
+		clone.Set(value)
+		for i := 0; i < value.NumField(); i++ {
+			if clone.Field(i).CanSet() {
+				clone.Field(i).Set(deepClone(value.Field(i), visits))
+			}
+		}
+		return clone
 	}
-	return false, false
+	return value
 }
@@ -0,0 +1,296 @@
+package utils
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TypeConverter converts values of SrcType into DstType while copying, e.g.
+// TypeConverter{SrcType: time.Time{}, DstType: "", Fn: ...}.
+// Pointer types are given as typed nil pointers, e.g. (*string)(nil).
+type TypeConverter struct {
+	SrcType interface{}
+	DstType interface{}
+	Fn      func(src interface{}) (dst interface{}, err error)
+}
+
+type converterPair struct {
+	src reflect.Type
+	dst reflect.Type
+}
+
+var converterRegistry = struct {
+	sync.RWMutex
+	converters map[converterPair]TypeConverter
+}{converters: map[converterPair]TypeConverter{}}
+
+// RegisterConverter registers a converter consulted by every copy, replacing the one of the same types
+func RegisterConverter(converter TypeConverter) error {
+	pair, err := converter.pair()
+	if err != nil {
+		return err
+	}
+	converterRegistry.Lock()
+	defer converterRegistry.Unlock()
+	converterRegistry.converters[pair] = converter
+	clearCopyPlans()
+	return nil
+}
+
+// UnregisterConverter removes the registered converter of the types
+func UnregisterConverter(srcType interface{}, dstType interface{}) {
+	converterRegistry.Lock()
+	defer converterRegistry.Unlock()
+	delete(converterRegistry.converters, converterPair{reflect.TypeOf(srcType), reflect.TypeOf(dstType)})
+	clearCopyPlans()
+}
+
+func (converter TypeConverter) pair() (converterPair, error) {
+	pair := converterPair{reflect.TypeOf(converter.SrcType), reflect.TypeOf(converter.DstType)}
+	if pair.src == nil || pair.dst == nil {
+		return pair, errors.New("converter types must not be nil")
+	}
+	if converter.Fn == nil {
+		return pair, fmt.Errorf("converter from %s to %s has no Fn", pair.src, pair.dst)
+	}
+	return pair, nil
+}
+
+// lookupConverter finds the converter of the types, the ones of opt taking precedence over registered ones
+func (opt CopyOption) lookupConverter(src reflect.Type, dst reflect.Type) (TypeConverter, bool) {
+	for _, converter := range opt.Converters {
+		if reflect.TypeOf(converter.SrcType) == src && reflect.TypeOf(converter.DstType) == dst && converter.Fn != nil {
+			return converter, true
+		}
+	}
+	converterRegistry.RLock()
+	defer converterRegistry.RUnlock()
+	converter, ok := converterRegistry.converters[converterPair{src, dst}]
+	return converter, ok
+}
+
+// convert sets to using the converter of the types, reporting whether there is one
+func convert(to reflect.Value, from reflect.Value, opt CopyOption) (bool, error) {
+	if !from.CanInterface() || !to.CanSet() {
+		return false, nil
+	}
+	converter, ok := opt.lookupConverter(from.Type(), to.Type())
+	if !ok {
+		return false, nil
+	}
+	result, err := converter.Fn(from.Interface())
+	if err != nil {
+		return true, fmt.Errorf("converting %s to %s: %w", from.Type(), to.Type(), err)
+	}
+
+	value := reflect.ValueOf(result)
+	switch {
+	case !value.IsValid():
+		to.Set(reflect.Zero(to.Type()))
+	case value.Type().AssignableTo(to.Type()):
+		to.Set(value)
+	case value.Type().ConvertibleTo(to.Type()):
+		to.Set(value.Convert(to.Type()))
+	default:
+		return true, fmt.Errorf("converter from %s to %s returned %s", from.Type(), to.Type(), value.Type())
+	}
+	return true, nil
+}
+
+// TimeToStringConverter formats time.Time values with layout, e.g. time.RFC3339
+func TimeToStringConverter(layout string) TypeConverter {
+	return TypeConverter{SrcType: time.Time{}, DstType: "", Fn: func(src interface{}) (interface{}, error) {
+		return src.(time.Time).Format(layout), nil
+	}}
+}
+
+// StringToTimeConverter parses strings into time.Time values with layout, e.g. time.RFC3339
+func StringToTimeConverter(layout string) TypeConverter {
+	return TypeConverter{SrcType: "", DstType: time.Time{}, Fn: func(src interface{}) (interface{}, error) {
+		return time.Parse(layout, src.(string))
+	}}
+}
+
+// BytesToBase64Converter encodes []byte values as standard base64 strings
+var BytesToBase64Converter = TypeConverter{SrcType: []byte(nil), DstType: "", Fn: func(src interface{}) (interface{}, error) {
+	return base64.StdEncoding.EncodeToString(src.([]byte)), nil
+}}
+
+// Base64ToBytesConverter decodes standard base64 strings into []byte values
+var Base64ToBytesConverter = TypeConverter{SrcType: "", DstType: []byte(nil), Fn: func(src interface{}) (interface{}, error) {
+	return base64.StdEncoding.DecodeString(src.(string))
+}}
+
+// StringerConverter converts values of the type of enum, which must implement fmt.Stringer, into strings
+func StringerConverter(enum fmt.Stringer) TypeConverter {
+	return TypeConverter{SrcType: enum, DstType: "", Fn: func(src interface{}) (interface{}, error) {
+		return src.(fmt.Stringer).String(), nil
+	}}
+}
+
+// isSemanticKind reports whether SemanticConversions applies to values of the kind
+func isSemanticKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+// convertSemantic sets to from a value of another kind, keeping its meaning rather than its bits
+func convertSemantic(to reflect.Value, from reflect.Value) error {
+	if from.Kind() == reflect.String {
+		return parseSemantic(to, from.String())
+	}
+	if to.Kind() == reflect.String {
+		to.SetString(formatSemantic(from))
+		return nil
+	}
+
+	switch {
+	case from.Kind() == reflect.Bool || to.Kind() == reflect.Bool:
+		return fmt.Errorf("cannot convert %s %v to %s", from.Type(), from, to.Type())
+	case from.CanInt():
+		return setInt(to, from.Int())
+	case from.CanUint():
+		return setUint(to, from.Uint())
+	}
+	return setFloat(to, from.Float())
+}
+
+func formatSemantic(from reflect.Value) string {
+	switch {
+	case from.Kind() == reflect.Bool:
+		return strconv.FormatBool(from.Bool())
+	case from.CanInt():
+		return strconv.FormatInt(from.Int(), 10)
+	case from.CanUint():
+		return strconv.FormatUint(from.Uint(), 10)
+	}
+	return strconv.FormatFloat(from.Float(), 'f', -1, from.Type().Bits())
+}
+
+func parseSemantic(to reflect.Value, s string) error {
+	switch {
+	case to.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		to.SetBool(b)
+	case to.CanInt():
+		i, err := strconv.ParseInt(s, 10, to.Type().Bits())
+		if err != nil {
+			return err
+		}
+		to.SetInt(i)
+	case to.CanUint():
+		u, err := strconv.ParseUint(s, 10, to.Type().Bits())
+		if err != nil {
+			return err
+		}
+		to.SetUint(u)
+	default:
+		f, err := strconv.ParseFloat(s, to.Type().Bits())
+		if err != nil {
+			return err
+		}
+		to.SetFloat(f)
+	}
+	return nil
+}
+
+func setInt(to reflect.Value, i int64) error {
+	switch {
+	case to.CanInt():
+		if to.OverflowInt(i) {
+			return overflowError(i, to)
+		}
+		to.SetInt(i)
+	case to.CanUint():
+		if i < 0 || to.OverflowUint(uint64(i)) {
+			return overflowError(i, to)
+		}
+		to.SetUint(uint64(i))
+	default:
+		return setExactFloat(to, i, float64(i), func(f float64) bool { return int64(f) == i })
+	}
+	return nil
+}
+
+func setUint(to reflect.Value, u uint64) error {
+	switch {
+	case to.CanInt():
+		if u > math.MaxInt64 || to.OverflowInt(int64(u)) {
+			return overflowError(u, to)
+		}
+		to.SetInt(int64(u))
+	case to.CanUint():
+		if to.OverflowUint(u) {
+			return overflowError(u, to)
+		}
+		to.SetUint(u)
+	default:
+		return setExactFloat(to, u, float64(u), func(f float64) bool { return uint64(f) == u })
+	}
+	return nil
+}
+
+func setFloat(to reflect.Value, f float64) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		if to.CanFloat() {
+			to.SetFloat(f)
+			return nil
+		}
+		return overflowError(f, to)
+	}
+	if !to.CanFloat() && f != math.Trunc(f) {
+		return fmt.Errorf("cannot convert %v to %s without losing precision", f, to.Type())
+	}
+	switch {
+	case to.CanInt():
+		// float64(math.MaxInt64) rounds up to 2^63, which is out of range already
+		if f < math.MinInt64 || f >= math.MaxInt64 || to.OverflowInt(int64(f)) {
+			return overflowError(f, to)
+		}
+		to.SetInt(int64(f))
+	case to.CanUint():
+		if f < 0 || f >= math.MaxUint64 || to.OverflowUint(uint64(f)) {
+			return overflowError(f, to)
+		}
+		to.SetUint(uint64(f))
+	default:
+		if to.OverflowFloat(f) {
+			return overflowError(f, to)
+		}
+		if to.Kind() == reflect.Float32 && float64(float32(f)) != f {
+			return fmt.Errorf("cannot convert %v to %s without losing precision", f, to.Type())
+		}
+		to.SetFloat(f)
+	}
+	return nil
+}
+
+// setExactFloat sets the integer value converted to float f, unless the float to stores differs from it
+func setExactFloat(to reflect.Value, value interface{}, f float64, exact func(float64) bool) error {
+	if to.Type().Bits() == 32 {
+		f = float64(float32(f))
+	}
+	if !exact(f) {
+		return fmt.Errorf("cannot convert %v to %s without losing precision", value, to.Type())
+	}
+	to.SetFloat(f)
+	return nil
+}
+
+func overflowError(value interface{}, to reflect.Value) error {
+	return fmt.Errorf("cannot convert %v to %s: value out of range", value, to.Type())
+}
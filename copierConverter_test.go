@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type decimal struct {
+	unscaled int64
+	scale    int
+}
+
+type orderStatus int
+
+func (s orderStatus) String() string {
+	return [...]string{"open", "paid"}[s]
+}
+
+type orderRecord struct {
+	Created   time.Time
+	Note      sql.NullString
+	Total     decimal
+	Status    orderStatus
+	Signature []byte
+}
+
+type orderDTO struct {
+	Created   string
+	Note      *string
+	Total     float64
+	Status    string
+	Signature string
+}
+
+var decimalConverter = TypeConverter{SrcType: decimal{}, DstType: float64(0), Fn: func(src interface{}) (interface{}, error) {
+	d := src.(decimal)
+	return float64(d.unscaled) / math.Pow10(d.scale), nil
+}}
+
+var nullStringConverter = TypeConverter{SrcType: sql.NullString{}, DstType: (*string)(nil), Fn: func(src interface{}) (interface{}, error) {
+	if s := src.(sql.NullString); s.Valid {
+		return &s.String, nil
+	}
+	return nil, nil
+}}
+
+func TestCopyWithOption_Converters(t *testing.T) {
+	record := orderRecord{
+		Created:   time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Note:      sql.NullString{String: "fragile", Valid: true},
+		Total:     decimal{unscaled: 1999, scale: 2},
+		Status:    1,
+		Signature: []byte("sig"),
+	}
+	opt := CopyOption{Converters: []TypeConverter{
+		TimeToStringConverter(time.RFC3339), nullStringConverter, decimalConverter,
+		StringerConverter(orderStatus(0)), BytesToBase64Converter,
+	}}
+
+	dto := orderDTO{}
+	assert.NoError(t, CopyWithOption(&dto, &record, opt))
+	assert.Equal(t, "2024-05-01T12:00:00Z", dto.Created)
+	assert.Equal(t, "fragile", *dto.Note)
+	assert.Equal(t, 19.99, dto.Total)
+	assert.Equal(t, "paid", dto.Status)
+	assert.Equal(t, "c2ln", dto.Signature)
+
+	record.Note = sql.NullString{}
+	assert.NoError(t, CopyWithOption(&dto, &record, opt))
+	assert.Nil(t, dto.Note)
+
+	back := orderRecord{}
+	assert.NoError(t, CopyWithOption(&back, &dto, CopyOption{Converters: []TypeConverter{
+		StringToTimeConverter(time.RFC3339), Base64ToBytesConverter,
+	}}))
+	assert.True(t, back.Created.Equal(record.Created))
+	assert.Equal(t, []byte("sig"), back.Signature)
+
+	var formatted string
+	assert.NoError(t, CopyWithOption(&formatted, record.Created, opt))
+	assert.Equal(t, "2024-05-01T12:00:00Z", formatted)
+}
+
+func TestRegisterConverter(t *testing.T) {
+	assert.NoError(t, RegisterConverter(decimalConverter))
+	defer UnregisterConverter(decimal{}, float64(0))
+
+	dto := orderDTO{}
+	assert.NoError(t, Copy(&dto, &orderRecord{Total: decimal{unscaled: 5, scale: 1}}))
+	assert.Equal(t, 0.5, dto.Total)
+
+	// converters given per call take precedence
+	assert.NoError(t, CopyWithOption(&dto, &orderRecord{Total: decimal{unscaled: 5}}, CopyOption{Converters: []TypeConverter{{
+		SrcType: decimal{}, DstType: float64(0), Fn: func(interface{}) (interface{}, error) { return 42, nil },
+	}}}))
+	assert.Equal(t, float64(42), dto.Total)
+
+	assert.Error(t, RegisterConverter(TypeConverter{SrcType: decimal{}}))
+	assert.Error(t, RegisterConverter(TypeConverter{SrcType: decimal{}, DstType: ""}))
+}
+
+func TestCopyWithOption_ConverterError(t *testing.T) {
+	err := CopyWithOption(&orderRecord{}, &orderDTO{Created: "yesterday"}, CopyOption{Converters: []TypeConverter{
+		StringToTimeConverter(time.RFC3339),
+	}})
+	var parseError *time.ParseError
+	assert.True(t, errors.As(err, &parseError))
+	assert.Contains(t, err.Error(), "converting string to time.Time")
+}
+
+func TestCopyWithOption_SemanticConversions(t *testing.T) {
+	type numbers struct {
+		Code   int
+		Price  float64
+		Count  string
+		Active string
+		Small  int64
+		Big    uint64
+	}
+	type formatted struct {
+		Code   string
+		Price  string
+		Count  int32
+		Active bool
+		Small  int8
+		Big    float64
+	}
+
+	to := formatted{}
+	assert.NoError(t, Copy(&to, &numbers{Code: 65}))
+	assert.Equal(t, "A", to.Code)
+
+	opt := CopyOption{SemanticConversions: true}
+	to = formatted{}
+	assert.NoError(t, CopyWithOption(&to, &numbers{Code: 65, Price: 9.5, Count: "12", Active: "true", Small: -3, Big: 1 << 40}, opt))
+	assert.Equal(t, formatted{Code: "65", Price: "9.5", Count: 12, Active: true, Small: -3, Big: 1 << 40}, to)
+
+	back := numbers{}
+	assert.NoError(t, CopyWithOption(&back, &to, opt))
+	assert.Equal(t, numbers{Code: 65, Price: 9.5, Count: "12", Active: "true", Small: -3, Big: 1 << 40}, back)
+
+	for _, invalid := range []interface{}{
+		&numbers{Count: "12.5"},
+		&numbers{Count: "3000000000"},
+		&numbers{Active: "maybe"},
+		&numbers{Small: 128},
+		&numbers{Big: 1<<53 + 1},
+	} {
+		assert.Error(t, CopyWithOption(&formatted{}, invalid, opt), "%+v", invalid)
+	}
+}
+
+func TestConvertSemantic(t *testing.T) {
+	var i int
+	var u uint8
+	var f32 float32
+	assert.EqualError(t, convertSemantic(reflect.ValueOf(&i).Elem(), reflect.ValueOf(2.5)),
+		"cannot convert 2.5 to int without losing precision")
+	assert.NoError(t, convertSemantic(reflect.ValueOf(&i).Elem(), reflect.ValueOf(2.0)))
+	assert.Equal(t, 2, i)
+	assert.EqualError(t, convertSemantic(reflect.ValueOf(&u).Elem(), reflect.ValueOf(-1)),
+		"cannot convert -1 to uint8: value out of range")
+	assert.EqualError(t, convertSemantic(reflect.ValueOf(&u).Elem(), reflect.ValueOf(1e300)),
+		"cannot convert 1e+300 to uint8: value out of range")
+	assert.EqualError(t, convertSemantic(reflect.ValueOf(&f32).Elem(), reflect.ValueOf(1e300)),
+		"cannot convert 1e+300 to float32: value out of range")
+	assert.EqualError(t, convertSemantic(reflect.ValueOf(&f32).Elem(), reflect.ValueOf(int64(16777217))),
+		"cannot convert 16777217 to float32 without losing precision")
+	assert.EqualError(t, convertSemantic(reflect.ValueOf(&f32).Elem(), reflect.ValueOf(16777217.0)),
+		"cannot convert 1.6777217e+07 to float32 without losing precision")
+	assert.EqualError(t, convertSemantic(reflect.ValueOf(&f32).Elem(), reflect.ValueOf(0.1)),
+		"cannot convert 0.1 to float32 without losing precision")
+	assert.NoError(t, convertSemantic(reflect.ValueOf(&f32).Elem(), reflect.ValueOf(0.5)))
+	assert.Equal(t, float32(0.5), f32)
+	assert.EqualError(t, convertSemantic(reflect.ValueOf(&i).Elem(), reflect.ValueOf(true)),
+		"cannot convert bool true to int")
+}
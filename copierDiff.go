@@ -0,0 +1,442 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Change is a difference between two values found by Diff
+type Change struct {
+	// Path of the field, element or map entry that changed, e.g. "Emails[1].Value" or `Labels["env"]`,
+	// empty when the values differ as a whole
+	Path string
+	// Old is the value before, nil for nil values and map entries added
+	Old interface{}
+	// New is the value after, nil for nil values and map entries removed
+	New interface{}
+}
+
+// ErrPatchConflict is wrapped by the errors of ApplyPatch for values that no longer match the old value of their change
+var ErrPatchConflict = errors.New("patch conflict")
+
+// Diff lists the changes from a to b, values of the same type. Exported struct fields, including the ones
+// of embedded structs, are found like Copy finds them, skipping fields tagged `copier:"-"`.
+// Structs, pointers, maps and slices of the same length are compared by element, other values as a whole,
+// using their Equal method when they have one, e.g. time.Time. Maps need string, bool or number keys,
+// which ApplyPatch parses back from the change paths.
+func Diff(a, b interface{}) ([]Change, error) {
+	from, to := reflect.ValueOf(a), reflect.ValueOf(b)
+	if from.IsValid() != to.IsValid() || (from.IsValid() && from.Type() != to.Type()) {
+		return nil, fmt.Errorf("cannot diff %T with %T", a, b)
+	}
+	d := &differ{visited: map[[2]uintptr]bool{}}
+	if from.IsValid() {
+		d.diff("", from, to)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return d.changes, nil
+}
+
+type differ struct {
+	changes []Change
+	// visited holds the pairs of pointers compared already, so cyclic values are compared once
+	visited map[[2]uintptr]bool
+	err     error
+}
+
+func (d *differ) diff(path string, a, b reflect.Value) {
+	if d.err != nil {
+		return
+	}
+	switch a.Kind() {
+	case reflect.Ptr:
+		if a.IsNil() || b.IsNil() {
+			if a.IsNil() != b.IsNil() {
+				d.add(path, a, b)
+			}
+			return
+		}
+		key := [2]uintptr{a.Pointer(), b.Pointer()}
+		if a.Pointer() == b.Pointer() || d.visited[key] {
+			return
+		}
+		d.visited[key] = true
+		d.diff(path, a.Elem(), b.Elem())
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() || a.Elem().Type() != b.Elem().Type() {
+			if !equalValues(a, b) {
+				d.add(path, a, b)
+			}
+			return
+		}
+		d.diff(path, a.Elem(), b.Elem())
+	case reflect.Struct:
+		if !hasExportedFields(a.Type()) {
+			if !equalValues(a, b) {
+				d.add(path, a, b)
+			}
+			return
+		}
+		for _, field := range visibleFields(a.Type()) {
+			if !field.IsExported() || parseCopierTag(field).Ignore {
+				continue
+			}
+			d.diff(joinPath(path, field.Name), promotedField(a, field.Name), promotedField(b, field.Name))
+		}
+	case reflect.Slice, reflect.Array:
+		if a.Len() != b.Len() {
+			d.add(path, a, b)
+			return
+		}
+		for i := 0; i < a.Len(); i++ {
+			d.diff(fmt.Sprintf("%s[%d]", path, i), a.Index(i), b.Index(i))
+		}
+	case reflect.Map:
+		if !isSemanticKind(a.Type().Key().Kind()) {
+			// other keys are not written into change paths in a way they can be parsed back
+			d.err = fmt.Errorf("cannot diff %s: map keys of type %s are not supported", a.Type(), a.Type().Key())
+			return
+		}
+		for _, key := range mapKeys(a, b) {
+			keyPath := path + "[" + formatMapKey(key) + "]"
+			fromValue, toValue := a.MapIndex(key), b.MapIndex(key)
+			if !fromValue.IsValid() || !toValue.IsValid() {
+				d.add(keyPath, fromValue, toValue)
+				continue
+			}
+			d.diff(keyPath, fromValue, toValue)
+		}
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		// not data
+	default:
+		if !equalValues(a, b) {
+			d.add(path, a, b)
+		}
+	}
+}
+
+func (d *differ) add(path string, a, b reflect.Value) {
+	d.changes = append(d.changes, Change{Path: path, Old: changeValue(a), New: changeValue(b)})
+}
+
+// changeValue clones the value, so the change shares no memory with the values compared
+func changeValue(value reflect.Value) interface{} {
+	if !value.IsValid() {
+		return nil
+	}
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		if value.IsNil() {
+			return nil
+		}
+	}
+	return deepClone(value, copyVisits{}).Interface()
+}
+
+// promotedField returns the named field, or its zero value when it is promoted through a nil pointer
+func promotedField(value reflect.Value, name string) reflect.Value {
+	field, _ := value.Type().FieldByName(name)
+	fieldValue, err := value.FieldByIndexErr(field.Index)
+	if err != nil {
+		return reflect.Zero(field.Type)
+	}
+	return fieldValue
+}
+
+// equalValues compares values with their Equal method, or deeply
+func equalValues(a, b reflect.Value) bool {
+	if !a.IsValid() || !b.IsValid() {
+		return a.IsValid() == b.IsValid()
+	}
+	if a.Type() == b.Type() {
+		if equal := a.MethodByName("Equal"); equal.IsValid() && equal.Type().NumIn() == 1 &&
+			equal.Type().In(0) == a.Type() && equal.Type().NumOut() == 1 && equal.Type().Out(0).Kind() == reflect.Bool {
+			return equal.Call([]reflect.Value{b})[0].Bool()
+		}
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// mapKeys returns the keys of both maps, sorted by their formatted value
+func mapKeys(a, b reflect.Value) []reflect.Value {
+	keys := a.MapKeys()
+	for _, key := range b.MapKeys() {
+		if !a.MapIndex(key).IsValid() {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+	})
+	return keys
+}
+
+func formatMapKey(key reflect.Value) string {
+	if key.Kind() == reflect.String {
+		return strconv.Quote(key.String())
+	}
+	return fmt.Sprint(key.Interface())
+}
+
+func joinPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// ApplyPatch replays the changes listed by Diff on dst, a pointer. Nothing is changed when the current value
+// of a change differs from its old value, the conflicts are returned as CopyErrors wrapping ErrPatchConflict,
+// nor when a change fails to apply, as the changes are tried on a clone of dst first.
+// Values are converted into the types of the fields like Copy does with SemanticConversions, so changes
+// decoded from JSON apply as well. Map entries changed to nil are deleted.
+func ApplyPatch(dst interface{}, changes []Change) error {
+	target := reflect.ValueOf(dst)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return fmt.Errorf("patch destination must be a non-nil pointer, got %T", dst)
+	}
+
+	paths := make([][]pathSegment, len(changes))
+	var conflicts CopyErrors
+	for i, change := range changes {
+		segments, err := parsePath(change.Path)
+		if err != nil {
+			return err
+		}
+		paths[i] = segments
+		if err = checkChange(target.Elem(), segments, change.Old); err != nil {
+			conflicts = append(conflicts, &CopyError{Path: change.Path, Err: err})
+		}
+	}
+	if len(conflicts) > 0 {
+		return conflicts
+	}
+
+	// the changes either all apply to the clone, and then to dst alike, or dst is left untouched
+	clone := reflect.New(target.Type().Elem())
+	clone.Elem().Set(deepClone(target.Elem(), copyVisits{}))
+	for _, value := range []reflect.Value{clone, target} {
+		for i, change := range changes {
+			if err := applyChange(value.Elem(), paths[i], change.New); err != nil {
+				return &CopyError{Path: change.Path, Err: err}
+			}
+		}
+	}
+	return nil
+}
+
+// pathSegment is a field name, or the index or map key between brackets of a change path
+type pathSegment struct {
+	field string
+	// key is the index or map key, quoted for string keys
+	key     string
+	bracket bool
+}
+
+func parsePath(path string) ([]pathSegment, error) {
+	var segments []pathSegment
+	rest := path
+	for rest != "" {
+		switch {
+		case rest[0] == '[':
+			key := rest[1:]
+			if strings.HasPrefix(key, `"`) {
+				quoted, err := strconv.QuotedPrefix(key)
+				if err != nil {
+					return nil, fmt.Errorf("invalid change path %q", path)
+				}
+				key = quoted
+			} else if end := strings.IndexByte(key, ']'); end >= 0 {
+				key = key[:end]
+			}
+			rest = rest[1+len(key):]
+			if !strings.HasPrefix(rest, "]") {
+				return nil, fmt.Errorf("invalid change path %q", path)
+			}
+			segments = append(segments, pathSegment{key: key, bracket: true})
+			rest = rest[1:]
+		case rest[0] == '.' && len(segments) > 0:
+			rest = rest[1:]
+			fallthrough
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid change path %q", path)
+			}
+			segments = append(segments, pathSegment{field: rest[:end]})
+			rest = rest[end:]
+		}
+	}
+	return segments, nil
+}
+
+// checkChange reports a conflict when the value at the path differs from old.
+// Values behind nil pointers and missing map entries are zero.
+func checkChange(value reflect.Value, segments []pathSegment, old interface{}) error {
+	for _, segment := range segments {
+		value = indirectZero(value)
+		next, err := pathElem(value, segment)
+		if err != nil {
+			return err
+		}
+		value = next
+	}
+
+	expected, err := patchValue(value.Type(), old)
+	if err != nil || !equalValues(value, expected) {
+		return fmt.Errorf("%w: expected %v, found %v", ErrPatchConflict, old, changeValue(value))
+	}
+	return nil
+}
+
+// indirectZero dereferences pointers and interfaces, reading nil ones as the zero value
+func indirectZero(value reflect.Value) reflect.Value {
+	for value.Kind() == reflect.Ptr || (value.Kind() == reflect.Interface && !value.IsNil()) {
+		if value.Kind() == reflect.Ptr && value.IsNil() {
+			return reflect.Zero(value.Type().Elem())
+		}
+		value = value.Elem()
+	}
+	return value
+}
+
+// pathElem returns the field, element or map entry of the value named by the segment
+func pathElem(value reflect.Value, segment pathSegment) (reflect.Value, error) {
+	switch {
+	case !segment.bracket && value.Kind() == reflect.Struct:
+		field, ok := value.Type().FieldByName(segment.field)
+		if !ok || !field.IsExported() {
+			return reflect.Value{}, fmt.Errorf("%s has no field %q", value.Type(), segment.field)
+		}
+		return promotedField(value, segment.field), nil
+	case segment.bracket && (value.Kind() == reflect.Slice || value.Kind() == reflect.Array):
+		i, err := strconv.Atoi(segment.key)
+		if err != nil || i < 0 || i >= value.Len() {
+			return reflect.Value{}, fmt.Errorf("index %s out of range", segment.key)
+		}
+		return value.Index(i), nil
+	case segment.bracket && value.Kind() == reflect.Map:
+		key, err := mapKeyValue(value.Type().Key(), segment.key)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if entry := value.MapIndex(key); entry.IsValid() {
+			return entry, nil
+		}
+		return reflect.Zero(value.Type().Elem()), nil
+	}
+	return reflect.Value{}, fmt.Errorf("cannot find %s%s in %s", segment.field, segment.key, value.Type())
+}
+
+// applyChange sets the value at the path, allocating nil pointers and maps leading to it
+func applyChange(value reflect.Value, segments []pathSegment, newValue interface{}) error {
+	if len(segments) == 0 {
+		converted, err := patchValue(value.Type(), newValue)
+		if err != nil {
+			return err
+		}
+		value.Set(converted)
+		return nil
+	}
+
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+		value = value.Elem()
+	}
+	segment := segments[0]
+	switch {
+	case value.Kind() == reflect.Interface && !value.IsNil():
+		// the dynamic value is changed in a copy
+		elem := reflect.New(value.Elem().Type()).Elem()
+		elem.Set(value.Elem())
+		if err := applyChange(elem, segments, newValue); err != nil {
+			return err
+		}
+		value.Set(elem)
+		return nil
+	case segment.bracket && value.Kind() == reflect.Map:
+		key, err := mapKeyValue(value.Type().Key(), segment.key)
+		if err != nil {
+			return err
+		}
+		if value.IsNil() {
+			value.Set(reflect.MakeMap(value.Type()))
+		}
+		if len(segments) == 1 && newValue == nil {
+			value.SetMapIndex(key, reflect.Value{})
+			return nil
+		}
+		// map entries are not addressable, the entry is changed in a copy
+		elem := reflect.New(value.Type().Elem()).Elem()
+		if entry := value.MapIndex(key); entry.IsValid() {
+			elem.Set(entry)
+		}
+		if err = applyChange(elem, segments[1:], newValue); err != nil {
+			return err
+		}
+		value.SetMapIndex(key, elem)
+		return nil
+	case !segment.bracket && value.Kind() == reflect.Struct:
+		field, ok := value.Type().FieldByName(segment.field)
+		if !ok || !field.IsExported() {
+			return fmt.Errorf("%s has no field %q", value.Type(), segment.field)
+		}
+		fieldValue := fieldByIndexAlloc(value, field.Index)
+		if !fieldValue.CanSet() {
+			return fmt.Errorf("cannot set %s.%s", value.Type(), segment.field)
+		}
+		return applyChange(fieldValue, segments[1:], newValue)
+	}
+
+	elem, err := pathElem(value, segment)
+	if err != nil {
+		return err
+	}
+	return applyChange(elem, segments[1:], newValue)
+}
+
+// patchValue converts a change value into the type, nil into the zero value
+func patchValue(valueType reflect.Type, value interface{}) (reflect.Value, error) {
+	converted := reflect.New(valueType).Elem()
+	if value == nil {
+		return converted, nil
+	}
+	if from := reflect.ValueOf(value); from.Type().AssignableTo(valueType) {
+		converted.Set(from)
+		return converted, nil
+	}
+	if valueType.Kind() == reflect.Ptr {
+		elem, err := patchValue(valueType.Elem(), value)
+		if err != nil {
+			return converted, err
+		}
+		converted.Set(reflect.New(valueType.Elem()))
+		converted.Elem().Set(elem)
+		return converted, nil
+	}
+	err := CopyWithOption(converted.Addr().Interface(), value, CopyOption{SemanticConversions: true, Strict: true})
+	return converted, err
+}
+
+// mapKeyValue parses a map key of a change path, quoted for string keys
+func mapKeyValue(keyType reflect.Type, key string) (reflect.Value, error) {
+	if strings.HasPrefix(key, `"`) {
+		unquoted, err := strconv.Unquote(key)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		key = unquoted
+	}
+	return patchValue(keyType, key)
+}
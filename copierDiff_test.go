@@ -0,0 +1,249 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type diffAudit struct {
+	Modified time.Time
+	Version  int
+}
+
+type diffName struct {
+	GivenName  string
+	FamilyName string
+}
+
+type diffEmail struct {
+	Value   string
+	Primary bool
+}
+
+type diffUser struct {
+	diffAudit
+	ID       string
+	Name     *diffName
+	Emails   []diffEmail
+	Labels   map[string]string
+	Scores   map[int]float64
+	Extra    interface{}
+	Password string `copier:"-"`
+	Next     *diffUser
+	internal string
+}
+
+func newDiffUser() diffUser {
+	return diffUser{
+		diffAudit: diffAudit{Modified: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Version: 1},
+		ID:        "1",
+		Name:      &diffName{GivenName: "John", FamilyName: "Doe"},
+		Emails:    []diffEmail{{Value: "jdoe@example.com", Primary: true}},
+		Labels:    map[string]string{"env": "prod", "team": "core"},
+		Scores:    map[int]float64{1: 0.5},
+		Extra:     "extra",
+		Password:  "secret",
+		internal:  "internal",
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old, updated := newDiffUser(), newDiffUser()
+	updated.Modified = time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)
+	updated.Version = 2
+	updated.Name = &diffName{GivenName: "Johnny", FamilyName: "Doe"}
+	updated.Emails = []diffEmail{{Value: "johnny@example.com", Primary: true}}
+	updated.Labels = map[string]string{"env": "dev", "tier": "gold"}
+	updated.Scores = map[int]float64{1: 0.5, 2: 1}
+	updated.Extra = 3
+	updated.Password = "changed"
+	updated.internal = "changed"
+
+	changes, err := Diff(old, updated)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []Change{
+			{Path: "Modified", Old: old.Modified, New: updated.Modified},
+			{Path: "Version", Old: 1, New: 2},
+			{Path: "Name.GivenName", Old: "John", New: "Johnny"},
+			{Path: "Emails[0].Value", Old: "jdoe@example.com", New: "johnny@example.com"},
+			{Path: `Labels["env"]`, Old: "prod", New: "dev"},
+			{Path: `Labels["team"]`, Old: "core", New: nil},
+			{Path: `Labels["tier"]`, Old: nil, New: "gold"},
+			{Path: "Scores[2]", Old: nil, New: 1.0},
+			{Path: "Extra", Old: "extra", New: 3},
+		}, changes)
+	}
+}
+
+func TestDiff_WholeValues(t *testing.T) {
+	old, updated := newDiffUser(), newDiffUser()
+	updated.Name = nil
+	updated.Emails = append(updated.Emails, diffEmail{Value: "john@example.com"})
+	updated.Modified = old.Modified.In(time.FixedZone("CET", 3600))
+
+	changes, err := Diff(&old, &updated)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []Change{
+			{Path: "Name", Old: &diffName{GivenName: "John", FamilyName: "Doe"}, New: nil},
+			{Path: "Emails", Old: old.Emails, New: updated.Emails},
+		}, changes)
+	}
+
+	// the values of changes are copies
+	old.Emails[0].Value = "changed"
+	assert.Equal(t, "jdoe@example.com", changes[1].Old.([]diffEmail)[0].Value)
+
+	changes, err = Diff(1, 2)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []Change{{Old: 1, New: 2}}, changes)
+	}
+	_, err = Diff(1, "2")
+	assert.EqualError(t, err, "cannot diff int with string")
+}
+
+func TestDiff_Cyclic(t *testing.T) {
+	old, updated := newDiffUser(), newDiffUser()
+	old.Next, updated.Next = &old, &updated
+	updated.ID = "2"
+
+	changes, err := Diff(&old, &updated)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []Change{{Path: "ID", Old: "1", New: "2"}}, changes)
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	old, updated := newDiffUser(), newDiffUser()
+	updated.Version = 2
+	updated.Name.GivenName = "Johnny"
+	updated.Emails[0].Primary = false
+	updated.Labels = map[string]string{"env": "dev", "tier": "gold"}
+	updated.Scores = map[int]float64{1: 0.75}
+	changes, err := Diff(old, updated)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	current := newDiffUser()
+	if assert.NoError(t, ApplyPatch(&current, changes)) {
+		assert.Equal(t, updated, current)
+	}
+}
+
+func TestApplyPatch_Allocates(t *testing.T) {
+	var user diffUser
+	changes := []Change{
+		{Path: "Version", Old: 0, New: 3},
+		{Path: "Name.FamilyName", Old: "", New: "Doe"},
+		{Path: `Labels["env"]`, Old: nil, New: "prod"},
+	}
+	if assert.NoError(t, ApplyPatch(&user, changes)) {
+		assert.Equal(t, 3, user.Version)
+		assert.Equal(t, &diffName{FamilyName: "Doe"}, user.Name)
+		assert.Equal(t, map[string]string{"env": "prod"}, user.Labels)
+	}
+}
+
+func TestApplyPatch_JSON(t *testing.T) {
+	old, updated := newDiffUser(), newDiffUser()
+	updated.Version = 2
+	updated.Name = nil
+	updated.Scores[1] = 2
+	changes, err := Diff(old, updated)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// changes stored in an audit log come back with JSON types
+	data, err := json.Marshal(changes)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var decoded []Change
+	if !assert.NoError(t, json.Unmarshal(data, &decoded)) {
+		return
+	}
+
+	current := newDiffUser()
+	if assert.NoError(t, ApplyPatch(&current, decoded)) {
+		assert.Equal(t, updated, current)
+	}
+}
+
+func TestApplyPatch_Conflicts(t *testing.T) {
+	current := newDiffUser()
+	current.Version = 5
+	changes := []Change{
+		{Path: "Version", Old: 1, New: 2},
+		{Path: "ID", Old: "1", New: "2"},
+		{Path: "Name.GivenName", Old: "Jane", New: "Janet"},
+	}
+
+	err := ApplyPatch(&current, changes)
+	assert.EqualError(t, err, "Version: patch conflict: expected 1, found 5; Name.GivenName: patch conflict: expected Jane, found John")
+	assert.True(t, errors.Is(err, ErrPatchConflict))
+	assert.Equal(t, "1", current.ID, "nothing is applied on conflicts")
+}
+
+func TestApplyPatch_Errors(t *testing.T) {
+	var user diffUser
+	assert.EqualError(t, ApplyPatch(user, nil), "patch destination must be a non-nil pointer, got utils.diffUser")
+	assert.EqualError(t, ApplyPatch(&user, []Change{{Path: "Name..GivenName"}}), `invalid change path "Name..GivenName"`)
+	assert.EqualError(t, ApplyPatch(&user, []Change{{Path: `Labels["env`}}), `invalid change path "Labels[\"env"`)
+	assert.EqualError(t, ApplyPatch(&user, []Change{{Path: "Unknown", New: 1}}), `Unknown: utils.diffUser has no field "Unknown"`)
+	assert.EqualError(t, ApplyPatch(&user, []Change{{Path: "Emails[0].Value", New: "x"}}), "Emails[0].Value: index 0 out of range")
+}
+
+func TestApplyPatch_Atomic(t *testing.T) {
+	type patched struct {
+		F int
+		S []int
+	}
+	current := patched{F: 1}
+	err := ApplyPatch(&current, []Change{{Path: "F", Old: 1, New: 5}, {Path: "S", Old: nil, New: "x"}})
+	assert.Error(t, err)
+	assert.Equal(t, patched{F: 1}, current, "nothing is applied when a change fails")
+}
+
+func TestDiff_MapRoundTrip(t *testing.T) {
+	type maps struct {
+		Names  map[string]string
+		Counts map[int]int
+		Flags  map[bool]string
+		Nested map[string]map[uint8]float64
+	}
+	before := maps{
+		Names:  map[string]string{"a]b": "1", `quo"te`: "2", "gone": "3"},
+		Counts: map[int]int{-1: 1, 2: 2},
+		Flags:  map[bool]string{true: "yes"},
+		Nested: map[string]map[uint8]float64{"x": {1: 0.5}},
+	}
+	after := maps{
+		Names:  map[string]string{"a]b": "changed", `quo"te`: "2", "new": "4"},
+		Counts: map[int]int{-1: 10},
+		Flags:  map[bool]string{true: "yes", false: "no"},
+		Nested: map[string]map[uint8]float64{"x": {1: 0.5, 255: 1.5}, "y": {}},
+	}
+
+	changes, err := Diff(before, after)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// an equal value built apart from before, as ApplyPatch changes its maps in place
+	patched := maps{
+		Names:  map[string]string{"a]b": "1", `quo"te`: "2", "gone": "3"},
+		Counts: map[int]int{-1: 1, 2: 2},
+		Flags:  map[bool]string{true: "yes"},
+		Nested: map[string]map[uint8]float64{"x": {1: 0.5}},
+	}
+	if assert.NoError(t, ApplyPatch(&patched, changes)) {
+		assert.Equal(t, after, patched)
+	}
+
+	_, err = Diff(map[[2]int]int{{1, 2}: 1}, map[[2]int]int{{1, 2}: 2})
+	assert.EqualError(t, err, "cannot diff map[[2]int]int: map keys of type [2]int are not supported")
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CopyError is a problem copying the field at Path, e.g. "Orders[3].Address.Zip"
+type CopyError struct {
+	Path string
+	Err  error
+}
+
+func (e *CopyError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *CopyError) Unwrap() error {
+	return e.Err
+}
+
+// CopyErrors are all the problems found by a strict copy
+type CopyErrors []*CopyError
+
+func (errs CopyErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (errs CopyErrors) Unwrap() []error {
+	unwrapped := make([]error, len(errs))
+	for i, err := range errs {
+		unwrapped[i] = err
+	}
+	return unwrapped
+}
+
+// fail collects err when copying strictly, returning it otherwise
+func (opt CopyOption) fail(err error) error {
+	if err == nil || opt.collected == nil {
+		return err
+	}
+	*opt.collected = append(*opt.collected, &CopyError{Path: opt.path, Err: err})
+	return nil
+}
+
+// field returns the options to copy the named field with, tracking its path when copying strictly
+func (opt CopyOption) field(name string) CopyOption {
+	if opt.collected != nil {
+		if opt.path != "" {
+			name = opt.path + "." + name
+		}
+		opt.path = name
+	}
+	return opt
+}
+
+// index returns the options to copy the element at index i with, tracking its path when copying strictly
+func (opt CopyOption) index(i int) CopyOption {
+	if opt.collected != nil {
+		opt.path += fmt.Sprintf("[%d]", i)
+	}
+	return opt
+}
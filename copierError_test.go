@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingScanner struct {
+	value string
+}
+
+func (s *failingScanner) Scan(src interface{}) error {
+	if text, ok := src.(string); ok {
+		s.value = text
+		return nil
+	}
+	return errors.New("unsupported source")
+}
+
+type strictAddress struct {
+	Street string
+	Zip    string
+}
+
+type strictAddressModel struct {
+	Street string
+	Zip    int8
+}
+
+type strictOrder struct {
+	ID      int
+	Address strictAddress
+	Placed  time.Time
+	Code    int
+	Comment string
+}
+
+type strictOrderModel struct {
+	ID      int
+	Address strictAddressModel
+	Placed  string
+	Code    failingScanner
+}
+
+func (m *strictOrderModel) Comment(comment []byte) {}
+
+type strictCustomer struct {
+	Name   string
+	Orders []strictOrder
+}
+
+type strictCustomerModel struct {
+	Name   string
+	Orders []strictOrderModel
+}
+
+func TestCopyWithOption_Strict(t *testing.T) {
+	customer := strictCustomer{Name: "John", Orders: []strictOrder{
+		{ID: 1, Address: strictAddress{Zip: "12"}},
+		{ID: 2, Address: strictAddress{Zip: "75001"}, Code: 3},
+	}}
+
+	// without Strict the first error stops the copy
+	model := strictCustomerModel{}
+	assert.EqualError(t, CopyWithOption(&model, &customer, CopyOption{SemanticConversions: true}),
+		`strconv.ParseInt: parsing "75001": value out of range`)
+
+	model = strictCustomerModel{}
+	err := CopyWithOption(&model, &customer, CopyOption{Strict: true, SemanticConversions: true})
+	var copyErrors CopyErrors
+	assert.True(t, errors.As(err, &copyErrors))
+	paths := make([]string, len(copyErrors))
+	for i, copyError := range copyErrors {
+		paths[i] = copyError.Path
+	}
+	assert.Equal(t, []string{
+		"Orders[0].Comment", "Orders[0].Placed", "Orders[0].Code",
+		"Orders[1].Comment", "Orders[1].Address.Zip", "Orders[1].Placed", "Orders[1].Code",
+	}, paths)
+	assert.Equal(t, "Orders[0].Comment: setter strictOrderModel.Comment does not take string; "+
+		"Orders[0].Placed: cannot copy time.Time into string; "+
+		"Orders[0].Code: unsupported source; "+
+		"Orders[1].Comment: setter strictOrderModel.Comment does not take string; "+
+		"Orders[1].Address.Zip: strconv.ParseInt: parsing \"75001\": value out of range; "+
+		"Orders[1].Placed: cannot copy time.Time into string; "+
+		"Orders[1].Code: unsupported source", err.Error())
+
+	var numError *strconv.NumError
+	assert.True(t, errors.As(err, &numError))
+	// everything else is copied still
+	assert.Equal(t, "John", model.Name)
+	assert.Equal(t, int8(12), model.Orders[0].Address.Zip)
+	assert.Len(t, model.Orders, 2)
+}
+
+func TestCopyWithOption_StrictMaps(t *testing.T) {
+	address := strictAddressModel{}
+	err := CopyWithOption(&address, map[string]interface{}{"Zip": "x", "City": "Paris", "Country": "FR"}, CopyOption{
+		Strict: true, SemanticConversions: true,
+	})
+	assert.EqualError(t, err, `Zip: strconv.ParseInt: parsing "x": invalid syntax; `+
+		`City: strictAddressModel has no field for key "City"; Country: strictAddressModel has no field for key "Country"`)
+
+	var zips []int8
+	err = CopyWithOption(&zips, []string{"1", "300"}, CopyOption{Strict: true, SemanticConversions: true})
+	assert.EqualError(t, err, `[1]: strconv.ParseInt: parsing "300": value out of range`)
+	assert.Equal(t, []int8{1, 0}, zips)
+
+	assert.EqualError(t, CopyWithOption(&address, &strictAddress{Zip: "1"}, CopyOption{Strict: true}),
+		"Zip: cannot copy string into int8")
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// CopyAs copies src into a new value of type T, allocating it when T is a pointer type.
+// At most one CopyOption may be given to customize the copy.
+func CopyAs[T any](src any, opts ...CopyOption) (T, error) {
+	var dst T
+	opt, err := copierOption(opts)
+	if err != nil {
+		return dst, err
+	}
+	target := reflect.ValueOf(&dst).Elem()
+	if target.Kind() == reflect.Ptr {
+		target.Set(reflect.New(target.Type().Elem()))
+	}
+	if err = CopyWithOption(&dst, src, opt); err != nil {
+		return dst, err
+	}
+	return dst, nil
+}
+
+// CopySlice copies every element of src into a new slice, nil for a nil src.
+// At most one CopyOption may be given to customize the copy.
+func CopySlice[D, S any](src []S, opts ...CopyOption) ([]D, error) {
+	opt, err := copierOption(opts)
+	if err != nil || src == nil {
+		return nil, err
+	}
+	dst := make([]D, 0, len(src))
+	if err = CopyWithOption(&dst, src, opt); err != nil {
+		return nil, err
+	}
+	if len(dst) != len(src) {
+		return nil, fmt.Errorf("cannot copy %T into %T", src, dst)
+	}
+	return dst, nil
+}
+
+// CopyMap copies every value of src into a new map with the same keys, nil for a nil src.
+// At most one CopyOption may be given to customize the copy.
+func CopyMap[K comparable, D, S any](src map[K]S, opts ...CopyOption) (map[K]D, error) {
+	if _, err := copierOption(opts); err != nil || src == nil {
+		return nil, err
+	}
+	dst := make(map[K]D, len(src))
+	for key, value := range src {
+		copied, err := CopyAs[D](value, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("copying key %v: %w", key, err)
+		}
+		dst[key] = copied
+	}
+	return dst, nil
+}
+
+// copierOption returns the optional CopyOption of the generic helpers, more than one is an error
+func copierOption(opts []CopyOption) (CopyOption, error) {
+	switch len(opts) {
+	case 0:
+		return CopyOption{}, nil
+	case 1:
+		return opts[0], nil
+	}
+	return CopyOption{}, fmt.Errorf("at most one CopyOption may be given, got %d", len(opts))
+}
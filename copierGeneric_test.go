@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type genericUser struct {
+	ID   int64
+	Name string
+}
+
+type genericUserDTO struct {
+	ID   int64
+	Name string `copier:"must"`
+}
+
+func TestCopyAs(t *testing.T) {
+	dto, err := CopyAs[genericUserDTO](genericUser{ID: 1, Name: "John"})
+	assert.NoError(t, err)
+	assert.Equal(t, genericUserDTO{ID: 1, Name: "John"}, dto)
+
+	pointer, err := CopyAs[*genericUserDTO](&genericUser{ID: 2, Name: "Anna"})
+	assert.NoError(t, err)
+	assert.Equal(t, &genericUserDTO{ID: 2, Name: "Anna"}, pointer)
+
+	count, err := CopyAs[int]("12", CopyOption{SemanticConversions: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 12, count)
+
+	_, err = CopyAs[genericUserDTO](struct{ ID int64 }{})
+	assert.Error(t, err)
+
+	_, err = CopyAs[int]("12", CopyOption{}, CopyOption{SemanticConversions: true})
+	assert.EqualError(t, err, "at most one CopyOption may be given, got 2")
+}
+
+func TestCopySlice(t *testing.T) {
+	dtos, err := CopySlice[genericUserDTO]([]genericUser{{ID: 1, Name: "John"}, {ID: 2, Name: "Anna"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []genericUserDTO{{ID: 1, Name: "John"}, {ID: 2, Name: "Anna"}}, dtos)
+
+	pointers, err := CopySlice[*genericUserDTO]([]*genericUser{{ID: 1, Name: "John"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []*genericUserDTO{{ID: 1, Name: "John"}}, pointers)
+
+	names, err := CopySlice[string]([]int{1, 2}, CopyOption{SemanticConversions: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, names)
+
+	dtos, err = CopySlice[genericUserDTO]([]genericUser(nil))
+	assert.NoError(t, err)
+	assert.Nil(t, dtos)
+
+	_, err = CopySlice[genericUserDTO]([]int{1})
+	assert.EqualError(t, err, "cannot copy int into utils.genericUserDTO")
+
+	_, err = CopySlice[string]([]int(nil), CopyOption{}, CopyOption{})
+	assert.Error(t, err)
+}
+
+func TestCopyMap(t *testing.T) {
+	dtos, err := CopyMap[string, genericUserDTO](map[string]genericUser{"john": {ID: 1, Name: "John"}})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]genericUserDTO{"john": {ID: 1, Name: "John"}}, dtos)
+
+	type anonymous struct{ ID int64 }
+	_, err = CopyMap[int, genericUserDTO](map[int]anonymous{7: {ID: 1}})
+	assert.EqualError(t, err, `copying key 7: field genericUserDTO.Name is tagged must but anonymous has no field or method "Name" to copy from`)
+}
@@ -0,0 +1,277 @@
+package utils
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// mapKey is the key of the field in maps copied from or into structs, empty for ignored fields
+func (opt CopyOption) mapKey(field reflect.StructField) string {
+	tag := parseCopierTag(field)
+	if tag.Ignore || !field.IsExported() {
+		return ""
+	}
+	if opt.MapKeyTag != "" {
+		if key, _, _ := strings.Cut(field.Tag.Get(opt.MapKeyTag), ","); key == "-" {
+			return ""
+		} else if key != "" {
+			return key
+		}
+	}
+	return tag.CopyName(field.Name)
+}
+
+// copyMapToStruct copies the values of a map with string keys into the fields of a struct
+func copyMapToStruct(to reflect.Value, from reflect.Value, opt CopyOption) error {
+	if from.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("cannot copy %s into %s: map keys must be strings", from.Type(), to.Type())
+	}
+	normalizedKeys, err := normalizedMapKeys(from, opt)
+	if err != nil {
+		return err
+	}
+
+	// copiedKeys tracks the keys copied, to report the others when copying strictly
+	copiedKeys := map[string]bool{}
+	for _, field := range deepFields(to.Type()) {
+		key := opt.mapKey(field)
+		if key == "" {
+			continue
+		}
+		value := from.MapIndex(reflect.ValueOf(key).Convert(from.Type().Key()))
+		if !value.IsValid() && len(opt.FieldNameNormalizers) > 0 {
+			if normalizedKey, ok := normalizedKeys[opt.normalizedName(reflect.StructField{Name: key})]; ok {
+				key = normalizedKey.String()
+				value = from.MapIndex(normalizedKey)
+			}
+		}
+		if !value.IsValid() {
+			if parseCopierTag(field).Must {
+				err = opt.fail(fmt.Errorf("field %s.%s is tagged must but the map has no key %q to copy from",
+					to.Type().Name(), field.Name, key))
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		copiedKeys[key] = true
+
+		if value.Kind() == reflect.Interface {
+			value = value.Elem()
+		}
+		toField := to.FieldByName(field.Name)
+		if !value.IsValid() {
+			// a nil value in the map
+			if !opt.IgnoreEmpty && (opt.Overwrite != OverwriteEmpty || toField.IsZero()) {
+				toField.Set(reflect.Zero(toField.Type()))
+			}
+			continue
+		}
+		if opt.IgnoreEmpty && value.IsZero() {
+			continue
+		}
+		if opt.DeepCopy {
+			value = deepClone(value, opt.visits)
+		}
+		fieldOpt := opt.field(key)
+		if err = fieldOpt.fail(setWithField(toField, value, fieldOpt)); err != nil {
+			return err
+		}
+	}
+
+	if opt.collected != nil {
+		var unmatched []string
+		for _, key := range from.MapKeys() {
+			if !copiedKeys[key.String()] {
+				unmatched = append(unmatched, key.String())
+			}
+		}
+		sort.Strings(unmatched)
+		for _, key := range unmatched {
+			_ = opt.field(key).fail(fmt.Errorf("%s has no field for key %q", to.Type().Name(), key))
+		}
+	}
+	return nil
+}
+
+// normalizedMapKeys indexes the keys of the map by their normalized name
+func normalizedMapKeys(from reflect.Value, opt CopyOption) (map[string]reflect.Value, error) {
+	keys := map[string]reflect.Value{}
+	if len(opt.FieldNameNormalizers) == 0 {
+		return keys, nil
+	}
+	for _, key := range from.MapKeys() {
+		normalized := opt.normalizedName(reflect.StructField{Name: key.String()})
+		if other, found := keys[normalized]; found {
+			return nil, fmt.Errorf("ambiguous field match: map keys %q and %q match the same field", other, key)
+		}
+		keys[normalized] = key
+	}
+	return keys, nil
+}
+
+// copyStructToMap copies the fields of a struct into a map with string keys, nested structs becoming maps
+// when the map holds interface values
+func copyStructToMap(to reflect.Value, from reflect.Value, opt CopyOption) error {
+	mapType := to.Type()
+	if mapType.Key().Kind() != reflect.String {
+		return fmt.Errorf("cannot copy %s into %s: map keys must be strings", from.Type(), mapType)
+	}
+	if to.IsNil() {
+		to.Set(reflect.MakeMap(mapType))
+	}
+
+	for _, field := range deepFields(from.Type()) {
+		key := opt.mapKey(field)
+		maskOpt, masked := opt.masked(field.Name)
+		if key == "" || !masked {
+			continue
+		}
+		fromField := from.FieldByName(field.Name)
+		if !fromField.IsValid() || (opt.IgnoreEmpty && fromField.IsZero()) {
+			continue
+		}
+		mapKey := reflect.ValueOf(key).Convert(mapType.Key())
+		if existing := to.MapIndex(mapKey); opt.Overwrite == OverwriteEmpty && existing.IsValid() && !existing.IsZero() {
+			continue
+		}
+
+		fieldOpt := maskOpt.field(field.Name)
+		value, err := mapValue(mapType.Elem(), fromField, fieldOpt)
+		if err != nil {
+			if err = fieldOpt.fail(fmt.Errorf("copying %s.%s: %w", from.Type().Name(), field.Name, err)); err != nil {
+				return err
+			}
+			continue
+		}
+		to.SetMapIndex(mapKey, value)
+	}
+	return nil
+}
+
+// mapValue converts the field value into a value of the element type of the map
+func mapValue(elemType reflect.Type, fromField reflect.Value, opt CopyOption) (reflect.Value, error) {
+	elem := reflect.New(elemType).Elem()
+	if elemType.Kind() == reflect.Interface {
+		if value := interfaceMapValue(fromField, opt); value.IsValid() {
+			elem.Set(value)
+		}
+		return elem, nil
+	}
+
+	if opt.DeepCopy {
+		fromField = deepClone(fromField, opt.visits)
+	}
+	if ok, err := set(elem, fromField, opt); err != nil {
+		return elem, err
+	} else if !ok {
+		if err = CopyWithOption(elem.Addr().Interface(), fromField.Interface(), opt); err != nil {
+			return elem, err
+		}
+	}
+	return elem, nil
+}
+
+// interfaceMapValue turns structs into maps, and slices of structs into slices of maps, keeping other values
+func interfaceMapValue(value reflect.Value, opt CopyOption) reflect.Value {
+	indirect := value
+	var pointer reflect.Value
+	for indirect.Kind() == reflect.Ptr || indirect.Kind() == reflect.Interface {
+		if indirect.IsNil() {
+			return reflect.Value{}
+		}
+		if indirect.Kind() == reflect.Ptr && !pointer.IsValid() {
+			pointer = indirect
+		}
+		indirect = indirect.Elem()
+	}
+
+	switch {
+	case indirect.Kind() == reflect.Struct && hasExportedFields(indirect.Type()):
+		nested := reflect.ValueOf(map[string]interface{}{})
+		if pointer.IsValid() && opt.visits != nil {
+			// a struct referenced again, possibly by itself, maps to the same map
+			key := visitKey{pointer.Pointer(), pointer.Type(), nested.Type()}
+			if copied, ok := opt.visits[key]; ok {
+				return copied
+			}
+			opt.visits[key] = nested
+		}
+		target := reflect.New(nested.Type()).Elem()
+		target.Set(nested)
+		_ = copyStructToMap(target, indirect, opt)
+		return nested
+	case (indirect.Kind() == reflect.Slice || indirect.Kind() == reflect.Array) && isStructElem(indirect.Type().Elem()):
+		if indirect.Kind() == reflect.Slice && indirect.IsNil() {
+			return reflect.Value{}
+		}
+		elements := make([]interface{}, indirect.Len())
+		for i := range elements {
+			if element := interfaceMapValue(indirect.Index(i), opt); element.IsValid() {
+				elements[i] = element.Interface()
+			}
+		}
+		return reflect.ValueOf(elements)
+	}
+	if opt.DeepCopy {
+		return deepClone(value, opt.visits)
+	}
+	return value
+}
+
+func isStructElem(elemType reflect.Type) bool {
+	for elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	return elemType.Kind() == reflect.Struct && hasExportedFields(elemType)
+}
+
+// isElementwiseSlice reports whether the elements of from are copied into the slice one by one,
+// e.g. maps decoded from JSON into structs
+func isElementwiseSlice(from reflect.Value, to reflect.Value) bool {
+	if from.Kind() != reflect.Slice && from.Kind() != reflect.Array {
+		return false
+	}
+	if from.Type().AssignableTo(to.Type()) {
+		return false
+	}
+	// slices of structs are copied field by field already
+	return IndirectType(from.Type()).Kind() != reflect.Struct
+}
+
+// copySliceElements appends the elements of from, converted one by one, to the slice
+func copySliceElements(to reflect.Value, from reflect.Value, opt CopyOption) error {
+	elemType := to.Type().Elem()
+	for i := 0; i < from.Len(); i++ {
+		elem := reflect.New(elemType).Elem()
+		elemOpt := opt.index(i)
+		if err := elemOpt.fail(copyElement(elem, from.Index(i), elemOpt)); err != nil {
+			return err
+		}
+		to.Set(reflect.Append(to, elem))
+	}
+	return nil
+}
+
+func copyElement(elem reflect.Value, source reflect.Value, opt CopyOption) error {
+	if source.Kind() == reflect.Interface {
+		source = source.Elem()
+	}
+	if !source.IsValid() {
+		return nil
+	}
+	if opt.DeepCopy {
+		source = deepClone(source, opt.visits)
+	}
+	ok, err := set(elem, source, opt)
+	if err != nil || ok {
+		return err
+	}
+	if kind := Indirect(source).Kind(); kind != reflect.Struct && kind != reflect.Map && kind != reflect.Slice {
+		return fmt.Errorf("cannot copy %s into %s", source.Type(), elem.Type())
+	}
+	return CopyWithOption(elem.Addr().Interface(), source.Interface(), opt)
+}
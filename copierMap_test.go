@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type documentAuthor struct {
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+}
+
+type document struct {
+	ID       int64            `json:"id"`
+	Title    string           `json:"title" copier:"must"`
+	Tags     []string         `json:"tags"`
+	Author   *documentAuthor  `json:"author"`
+	Editors  []documentAuthor `json:"editors"`
+	Secret   string           `json:"-"`
+	Created  time.Time        `json:"created"`
+	Revision *int             `json:"revision"`
+}
+
+func TestCopyMapToStruct(t *testing.T) {
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(`{
+		"id": 7, "title": "Report", "tags": ["a", "b"], "secret": "x", "revision": null,
+		"author": {"name": "John", "email": "john@example.org"},
+		"editors": [{"name": "Anna"}, {"name": "Bert"}]
+	}`), &decoded))
+
+	revision := 3
+	doc := document{Secret: "kept", Revision: &revision}
+	assert.NoError(t, CopyWithOption(&doc, decoded, CopyOption{MapKeyTag: "json"}))
+	assert.Equal(t, document{
+		ID: 7, Title: "Report", Tags: []string{"a", "b"}, Secret: "kept",
+		Author:  &documentAuthor{Name: "John", Email: "john@example.org"},
+		Editors: []documentAuthor{{Name: "Anna"}, {Name: "Bert"}},
+	}, doc)
+
+	// keyed by field name without MapKeyTag
+	doc = document{}
+	assert.NoError(t, Copy(&doc, map[string]interface{}{"Title": "Report", "ID": 7, "Author": map[string]string{"Name": "Anna"}}))
+	assert.Equal(t, document{ID: 7, Title: "Report", Author: &documentAuthor{Name: "Anna"}}, doc)
+
+	assert.EqualError(t, Copy(&doc, map[string]interface{}{"ID": 1}),
+		`field document.Title is tagged must but the map has no key "Title" to copy from`)
+	assert.Error(t, Copy(&doc, map[int]interface{}{1: "x"}))
+}
+
+func TestCopyMapToStruct_Options(t *testing.T) {
+	doc := document{Title: "Report", ID: 7}
+	assert.NoError(t, CopyWithOption(&doc, map[string]interface{}{"title": "", "id": 8, "tags": nil}, CopyOption{
+		MapKeyTag: "json", IgnoreEmpty: true,
+	}))
+	assert.Equal(t, document{ID: 8, Title: "Report"}, doc)
+
+	assert.NoError(t, CopyWithOption(&doc, map[string]interface{}{"Title": "Draft", "revision": "5"}, CopyOption{
+		FieldNameNormalizers: []FieldNameNormalizer{MatchCaseInsensitive}, SemanticConversions: true,
+	}))
+	assert.Equal(t, "Draft", doc.Title)
+	assert.Equal(t, 5, *doc.Revision)
+
+	assert.Error(t, CopyWithOption(&doc, map[string]interface{}{"title": "a", "TITLE": "b"}, CopyOption{
+		FieldNameNormalizers: []FieldNameNormalizer{MatchCaseInsensitive},
+	}))
+}
+
+func TestCopyStructToMap(t *testing.T) {
+	created := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	doc := document{
+		ID: 7, Title: "Report", Tags: []string{"a"}, Secret: "x", Created: created,
+		Author:  &documentAuthor{Name: "John"},
+		Editors: []documentAuthor{{Name: "Anna", Email: "anna@example.org"}},
+	}
+
+	m := map[string]interface{}{}
+	assert.NoError(t, CopyWithOption(&m, &doc, CopyOption{MapKeyTag: "json"}))
+	assert.Equal(t, map[string]interface{}{
+		"id": int64(7), "title": "Report", "tags": []string{"a"}, "created": created, "revision": nil,
+		"author":  map[string]interface{}{"name": "John", "email": ""},
+		"editors": []interface{}{map[string]interface{}{"name": "Anna", "email": "anna@example.org"}},
+	}, m)
+
+	var names map[string]string
+	assert.NoError(t, CopyWithOption(&names, documentAuthor{Name: "John"}, CopyOption{IgnoreEmpty: true}))
+	assert.Equal(t, map[string]string{"Name": "John"}, names)
+
+	// decoded documents round trip into typed models
+	back := document{}
+	assert.NoError(t, CopyWithOption(&back, m, CopyOption{MapKeyTag: "json"}))
+	doc.Secret = ""
+	assert.Equal(t, doc, back)
+}
+
+func TestCopySliceElements(t *testing.T) {
+	var authors []documentAuthor
+	assert.NoError(t, Copy(&authors, []interface{}{map[string]interface{}{"Name": "Anna"}, nil}))
+	assert.Equal(t, []documentAuthor{{Name: "Anna"}, {}}, authors)
+
+	var counts []int
+	assert.NoError(t, CopyWithOption(&counts, []string{"1", "2"}, CopyOption{SemanticConversions: true}))
+	assert.Equal(t, []int{1, 2}, counts)
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// fieldMask holds the masked fields of a struct by source field name, each with the masked fields
+// inside it, or nil when the field is copied whole
+type fieldMask map[string]fieldMask
+
+// compileFieldMask resolves the dotted paths against the source type, failing on unknown fields
+func compileFieldMask(paths []string, fromType reflect.Type) (fieldMask, error) {
+	mask := fieldMask{}
+	for _, path := range paths {
+		node, nodeType := mask, fromType
+		segments := strings.Split(path, ".")
+		for i, segment := range segments {
+			field, err := maskField(nodeType, segment)
+			if err != nil && i == len(segments)-1 {
+				// the last segment may name a getter method of the source instead
+				if name, ok := maskGetter(nodeType, segment); ok {
+					field, err = reflect.StructField{Name: name}, nil
+				}
+			}
+			if err != nil {
+				return nil, fmt.Errorf("unknown field mask path %q: %w", path, err)
+			}
+			child, seen := node[field.Name]
+			if i == len(segments)-1 {
+				node[field.Name] = nil
+				break
+			}
+			if seen && child == nil {
+				// already copied whole
+				break
+			}
+			if child == nil {
+				child = fieldMask{}
+				node[field.Name] = child
+			}
+			node, nodeType = child, field.Type
+		}
+	}
+	return mask, nil
+}
+
+// maskStructType returns the struct type of pointers, slices and arrays
+func maskStructType(structType reflect.Type) reflect.Type {
+	for structType != nil && (structType.Kind() == reflect.Ptr || structType.Kind() == reflect.Slice ||
+		structType.Kind() == reflect.Array) {
+		structType = structType.Elem()
+	}
+	return structType
+}
+
+// maskField finds the field named by a path segment in a struct, or in the structs of a slice
+func maskField(structType reflect.Type, segment string) (reflect.StructField, error) {
+	structType = maskStructType(structType)
+	if structType == nil || structType.Kind() != reflect.Struct {
+		return reflect.StructField{}, fmt.Errorf("%v has no fields", structType)
+	}
+	for _, field := range deepFields(structType) {
+		if !field.IsExported() {
+			continue
+		}
+		jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if strings.EqualFold(segment, field.Name) || strings.EqualFold(segment, parseCopierTag(field).CopyName(field.Name)) ||
+			strings.EqualFold(segment, jsonName) {
+			return field, nil
+		}
+	}
+	return reflect.StructField{}, fmt.Errorf("%s has no field %q", structType.Name(), segment)
+}
+
+// maskGetter finds the method without arguments named by a path segment, whose result copyStruct copies
+func maskGetter(structType reflect.Type, segment string) (string, bool) {
+	structType = maskStructType(structType)
+	if structType == nil || structType.Kind() != reflect.Struct {
+		return "", false
+	}
+	receiver := reflect.PtrTo(structType)
+	for i := 0; i < receiver.NumMethod(); i++ {
+		if method := receiver.Method(i); method.Type.NumIn() == 1 && strings.EqualFold(segment, method.Name) {
+			return method.Name, true
+		}
+	}
+	return "", false
+}
+
+// masked reports whether the named source field is copied, and the option to copy it with
+func (opt CopyOption) masked(name string) (CopyOption, bool) {
+	if opt.mask == nil {
+		return opt, true
+	}
+	child, ok := opt.mask[name]
+	opt.mask = child
+	return opt, ok
+}
+
+// copyMasked copies the masked fields nested in the source field only
+func copyMasked(toField, fromField reflect.Value, opt CopyOption) error {
+	if fromField.Kind() == reflect.Ptr && fromField.IsNil() {
+		toField.Set(reflect.Zero(toField.Type()))
+		return nil
+	}
+	switch {
+	case toField.Kind() == reflect.Ptr && toField.IsNil():
+		toField.Set(reflect.New(toField.Type().Elem()))
+	case toField.Kind() == reflect.Slice:
+		// replace the elements like copying the whole slice does, rather than appending to them
+		toField.Set(reflect.Zero(toField.Type()))
+	}
+	return CopyWithOption(toField.Addr().Interface(), fromField.Interface(), opt)
+}
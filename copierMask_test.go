@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type maskName struct {
+	GivenName  string `json:"givenName"`
+	FamilyName string `json:"familyName"`
+}
+
+type maskEmail struct {
+	Value   string `json:"value"`
+	Primary bool   `json:"primary"`
+}
+
+type maskUser struct {
+	ID       string      `json:"id"`
+	UserName string      `json:"userName"`
+	Name     *maskName   `json:"name"`
+	Emails   []maskEmail `json:"emails"`
+	Groups   []string    `json:"groups"`
+}
+
+func newMaskUser() maskUser {
+	return maskUser{
+		ID:       "1",
+		UserName: "jdoe",
+		Name:     &maskName{GivenName: "John", FamilyName: "Doe"},
+		Emails:   []maskEmail{{Value: "jdoe@example.com", Primary: true}, {Value: "john@example.com"}},
+		Groups:   []string{"admins"},
+	}
+}
+
+func TestCopyWithOption_FieldMask(t *testing.T) {
+	var to maskUser
+	err := CopyWithOption(&to, newMaskUser(), CopyOption{FieldMask: []string{"id", "name.givenName", "emails.value"}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, maskUser{
+			ID:     "1",
+			Name:   &maskName{GivenName: "John"},
+			Emails: []maskEmail{{Value: "jdoe@example.com"}, {Value: "john@example.com"}},
+		}, to)
+	}
+}
+
+func TestCopyWithOption_FieldMaskWholeField(t *testing.T) {
+	from := newMaskUser()
+	var to maskUser
+	err := CopyWithOption(&to, &from, CopyOption{FieldMask: []string{"name.givenName", "NAME", "groups"}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, maskUser{Name: &maskName{GivenName: "John", FamilyName: "Doe"}, Groups: []string{"admins"}}, to)
+	}
+}
+
+func TestCopyWithOption_FieldMaskSlices(t *testing.T) {
+	var to []maskUser
+	err := CopyWithOption(&to, []maskUser{newMaskUser(), {UserName: "asmith"}}, CopyOption{FieldMask: []string{"userName", "name.familyName"}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []maskUser{{UserName: "jdoe", Name: &maskName{FamilyName: "Doe"}}, {UserName: "asmith"}}, to)
+	}
+}
+
+func TestCopyWithOption_FieldMaskToMap(t *testing.T) {
+	to := map[string]interface{}{}
+	err := CopyWithOption(&to, newMaskUser(), CopyOption{MapKeyTag: "json", FieldMask: []string{"userName", "emails.primary"}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]interface{}{
+			"userName": "jdoe",
+			"emails":   []interface{}{map[string]interface{}{"primary": true}, map[string]interface{}{"primary": false}},
+		}, to)
+	}
+}
+
+func TestCopyWithOption_FieldMaskUnknownPath(t *testing.T) {
+	var to maskUser
+	err := CopyWithOption(&to, newMaskUser(), CopyOption{FieldMask: []string{"name.middleName"}})
+	assert.EqualError(t, err, `unknown field mask path "name.middleName": maskName has no field "middleName"`)
+
+	err = CopyWithOption(&to, newMaskUser(), CopyOption{FieldMask: []string{"groups.value"}})
+	assert.EqualError(t, err, `unknown field mask path "groups.value": string has no fields`)
+	assert.Equal(t, maskUser{}, to)
+}
+
+type maskAccount struct {
+	First string
+	Last  string
+	Extra string
+}
+
+func (a maskAccount) FullName() string {
+	return a.First + " " + a.Last
+}
+
+type maskAccountDTO struct {
+	First    string
+	FullName string
+}
+
+func TestCopyWithOption_FieldMaskGetters(t *testing.T) {
+	from := maskAccount{First: "John", Last: "Doe", Extra: "x"}
+
+	var to maskAccountDTO
+	err := CopyWithOption(&to, from, CopyOption{FieldMask: []string{"first", "fullName"}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, maskAccountDTO{First: "John", FullName: "John Doe"}, to)
+	}
+
+	// Last and Extra have no destination, but the mask leaves them out of the strict copy
+	to = maskAccountDTO{}
+	err = CopyWithOption(&to, from, CopyOption{FieldMask: []string{"first"}, Strict: true})
+	if assert.NoError(t, err) {
+		assert.Equal(t, maskAccountDTO{First: "John"}, to)
+	}
+	err = CopyWithOption(&to, from, CopyOption{FieldMask: []string{"first", "extra"}, Strict: true})
+	assert.Error(t, err)
+}
@@ -0,0 +1,181 @@
+package utils
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// CopyOption customizes CopyWithOption
+type CopyOption struct {
+	// FieldNameNormalizers match fields whose names differ, when no field has the exact name.
+	// They are applied in order to the names of both sides, e.g.
+	// []FieldNameNormalizer{MatchJsonTag, MatchSnakeCase, MatchAcronyms}
+	FieldNameNormalizers []FieldNameNormalizer
+	// DeepCopy clones maps, slices, arrays and pointers, so the copy shares no mutable memory with the source
+	DeepCopy bool
+	// IgnoreEmpty skips zero and nil source values, e.g. to apply a partial update.
+	// A pointer to a zero value is not empty and sets the destination to zero.
+	IgnoreEmpty bool
+	// Overwrite decides which destination fields are set, OverwriteAlways by default
+	Overwrite OverwritePolicy
+	// Converters are consulted before the ones registered with RegisterConverter and the built-in conversions
+	Converters []TypeConverter
+	// SemanticConversions formats and parses numbers, bools and strings with strconv instead of
+	// converting them the Go way, failing on overflow and precision loss
+	SemanticConversions bool
+	// MapKeyTag names the struct tag, e.g. "json", holding the map keys of fields when copying between
+	// maps and structs. Fields are keyed by their copier name otherwise.
+	MapKeyTag string
+	// Strict reports source fields without a destination, values that cannot be converted and scanner
+	// failures instead of skipping them, collecting every problem into CopyErrors
+	Strict bool
+	// FieldMask restricts the copy to the dotted paths of struct source fields, e.g. name.givenName or
+	// emails.value for the value of every element of emails. Path segments match field names, copier
+	// names and json tags ignoring case, the last one may name a getter method of the source instead.
+	// Strict copies report problems of masked fields only.
+	FieldMask []string
+
+	// collected gathers the problems of a strict copy, found at path
+	collected *CopyErrors
+	path      string
+	// visits tracks the pointers copied, from the outermost copy on
+	visits copyVisits
+	// mask is the compiled FieldMask of the values copied, nil when copying every field
+	mask fieldMask
+}
+
+// patching reports whether fields are merged into the destination rather than replacing it
+func (opt CopyOption) patching() bool {
+	return opt.IgnoreEmpty || opt.Overwrite != OverwriteAlways
+}
+
+// OverwritePolicy decides which destination fields a copy may set
+type OverwritePolicy int
+
+const (
+	// OverwriteAlways sets every matched destination field
+	OverwriteAlways OverwritePolicy = iota
+	// OverwriteEmpty only sets destination fields holding their zero value
+	OverwriteEmpty
+)
+
+// FieldNameNormalizer maps the name of a field to the key fields are matched by
+type FieldNameNormalizer func(field reflect.StructField, name string) string
+
+// MatchCaseInsensitive matches UserId with userid
+func MatchCaseInsensitive(_ reflect.StructField, name string) string {
+	return strings.ToLower(name)
+}
+
+// MatchSnakeCase matches user_id with UserId
+func MatchSnakeCase(_ reflect.StructField, name string) string {
+	if !strings.Contains(name, "_") {
+		return name
+	}
+	var camel strings.Builder
+	for _, word := range strings.Split(name, "_") {
+		if word != "" {
+			runes := []rune(word)
+			camel.WriteRune(unicode.ToUpper(runes[0]))
+			camel.WriteString(string(runes[1:]))
+		}
+	}
+	return camel.String()
+}
+
+// MatchAcronyms matches UserID with UserId and HTTPServer with HttpServer
+func MatchAcronyms(_ reflect.StructField, name string) string {
+	var normalized strings.Builder
+	for _, word := range splitWords(name) {
+		runes := []rune(strings.ToLower(word))
+		normalized.WriteRune(unicode.ToUpper(runes[0]))
+		normalized.WriteString(string(runes[1:]))
+	}
+	return normalized.String()
+}
+
+// MatchJsonTag matches fields by the name of their json tag, fields without one by name
+func MatchJsonTag(field reflect.StructField, name string) string {
+	jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if jsonName == "" || jsonName == "-" {
+		return name
+	}
+	return jsonName
+}
+
+// splitWords splits a CamelCase name into its words, keeping acronyms together
+func splitWords(name string) []string {
+	runes := []rune(name)
+	var words []string
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		prev, current := runes[i-1], runes[i]
+		nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+		if unicode.IsUpper(current) && (!unicode.IsUpper(prev) || nextIsLower) {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	if start < len(runes) {
+		words = append(words, string(runes[start:]))
+	}
+	return words
+}
+
+func (opt CopyOption) normalizedName(field reflect.StructField) string {
+	name := parseCopierTag(field).CopyName(field.Name)
+	for _, normalizer := range opt.FieldNameNormalizers {
+		name = normalizer(field, name)
+	}
+	return name
+}
+
+// fieldByCopyName finds the field matched by name among the visible fields of a struct
+func fieldByCopyName(fields []reflect.StructField, name string) (reflect.StructField, bool) {
+	for _, field := range fields {
+		if parseCopierTag(field).CopyName(field.Name) == name {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// matchField finds the field of toType, among its visible toFields, the source field is copied into.
+// A field with the exact name wins, otherwise the normalized names have to match exactly one field
+// that no other source field matches by exact name.
+func matchField(field reflect.StructField, fromFields []reflect.StructField, toType reflect.Type, toFields []reflect.StructField,
+	opt CopyOption) (reflect.StructField, bool, error) {
+	name := parseCopierTag(field).CopyName(field.Name)
+	if toField, ok := fieldByCopyName(toFields, name); ok || len(opt.FieldNameNormalizers) == 0 {
+		return toField, ok, nil
+	}
+
+	exactNames := map[string]bool{}
+	for _, fromField := range fromFields {
+		if tag := parseCopierTag(fromField); !tag.Ignore {
+			exactNames[tag.CopyName(fromField.Name)] = true
+		}
+	}
+
+	key := opt.normalizedName(field)
+	var matches []reflect.StructField
+	for _, toField := range toFields {
+		if !exactNames[parseCopierTag(toField).CopyName(toField.Name)] && opt.normalizedName(toField) == key {
+			matches = append(matches, toField)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return reflect.StructField{}, false, nil
+	case 1:
+		return matches[0], true, nil
+	}
+	names := make([]string, len(matches))
+	for i, match := range matches {
+		names[i] = match.Name
+	}
+	return reflect.StructField{}, false, fmt.Errorf("ambiguous field match: %s matches %s.%s",
+		field.Name, toType.Name(), strings.Join(names, " and "))
+}
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type generatedAccount struct {
+	UserId     int64  `json:"user_id"`
+	Account_No string `json:"account_no"`
+	HttpUrl    string `json:"homepage"`
+}
+
+type Account struct {
+	UserID    int64
+	AccountNo string
+	Homepage  string
+}
+
+func TestFieldNameNormalizers(t *testing.T) {
+	tests := []struct {
+		normalizer FieldNameNormalizer
+		name       string
+		want       string
+	}{
+		{MatchCaseInsensitive, "UserID", "userid"},
+		{MatchSnakeCase, "user_id", "UserId"},
+		{MatchSnakeCase, "UserID", "UserID"},
+		{MatchAcronyms, "UserID", "UserId"},
+		{MatchAcronyms, "HTTPServerURL", "HttpServerUrl"},
+		{MatchAcronyms, "userId", "UserId"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.normalizer(reflect.StructField{}, tt.name), tt.name)
+	}
+
+	field, _ := reflect.TypeOf(generatedAccount{}).FieldByName("HttpUrl")
+	assert.Equal(t, "homepage", MatchJsonTag(field, "HttpUrl"))
+}
+
+func TestCopyWithOption_Normalizers(t *testing.T) {
+	from := generatedAccount{UserId: 7, Account_No: "NL01", HttpUrl: "https://example.org"}
+
+	account := Account{}
+	assert.NoError(t, Copy(&account, &from))
+	assert.Equal(t, Account{}, account)
+
+	assert.NoError(t, CopyWithOption(&account, &from, CopyOption{
+		FieldNameNormalizers: []FieldNameNormalizer{MatchSnakeCase, MatchAcronyms},
+	}))
+	assert.Equal(t, Account{UserID: 7, AccountNo: "NL01"}, account)
+
+	account = Account{}
+	assert.NoError(t, CopyWithOption(&account, &from, CopyOption{
+		FieldNameNormalizers: []FieldNameNormalizer{MatchJsonTag, MatchSnakeCase, MatchCaseInsensitive},
+	}))
+	assert.Equal(t, Account{UserID: 7, AccountNo: "NL01", Homepage: "https://example.org"}, account)
+
+	accounts := []generatedAccount{}
+	assert.NoError(t, CopyWithOption(&accounts, []Account{account}, CopyOption{
+		FieldNameNormalizers: []FieldNameNormalizer{MatchAcronyms},
+	}))
+	assert.Equal(t, []generatedAccount{{UserId: 7}}, accounts)
+}
+
+func TestCopyWithOption_Ambiguous(t *testing.T) {
+	type target struct {
+		UserID int64
+		UserId int64
+	}
+	opt := CopyOption{FieldNameNormalizers: []FieldNameNormalizer{MatchSnakeCase, MatchCaseInsensitive}}
+
+	err := CopyWithOption(&target{}, &struct{ User_Id int64 }{1}, opt)
+	assert.EqualError(t, err, "ambiguous field match: User_Id matches target.UserID and UserId")
+
+	type source struct {
+		User_Id int64
+		USER_ID int64
+	}
+	err = CopyWithOption(&struct{ UserID int64 }{}, &source{1, 2}, opt)
+	assert.EqualError(t, err, "ambiguous field match: .UserID matches both User_Id and USER_ID")
+
+	// fields matched by their exact name are left out of the normalized matches
+	to := target{}
+	assert.NoError(t, CopyWithOption(&to, &struct{ UserId, User_id int64 }{1, 2}, opt))
+	assert.Equal(t, target{UserID: 2, UserId: 1}, to)
+}
+
+func TestCopy_ShadowedFields(t *testing.T) {
+	type Base struct {
+		ID   int
+		Name string
+	}
+	type Derived struct {
+		Base
+		ID int
+	}
+	type Out struct {
+		ID   int
+		Name string
+	}
+
+	out := Out{}
+	assert.NoError(t, Copy(&out, &Derived{Base: Base{ID: 1, Name: "n"}, ID: 2}))
+	assert.Equal(t, Out{ID: 2, Name: "n"}, out)
+
+	opt := CopyOption{FieldNameNormalizers: []FieldNameNormalizer{MatchCaseInsensitive}}
+	out = Out{}
+	assert.NoError(t, CopyWithOption(&out, &Derived{Base: Base{ID: 1, Name: "n"}, ID: 2}, opt))
+	assert.Equal(t, Out{ID: 2, Name: "n"}, out)
+
+	// the shadowed destination field is left alone
+	derived := Derived{}
+	assert.NoError(t, Copy(&derived, &Out{ID: 3, Name: "m"}))
+	assert.Equal(t, Derived{Base: Base{Name: "m"}, ID: 3}, derived)
+}
+
+type profilePatch struct {
+	Name    string
+	Age     *int
+	Active  *bool
+	Tags    []string
+	Address *addressPatch
+}
+
+type addressPatch struct {
+	City   string
+	Street string
+}
+
+type profile struct {
+	Name    string
+	Age     int
+	Active  bool
+	Tags    []string
+	Address address
+}
+
+type address struct {
+	City   string
+	Street string
+}
+
+func TestCopyWithOption_IgnoreEmpty(t *testing.T) {
+	stored := profile{Name: "John", Age: 42, Active: true, Tags: []string{"a"},
+		Address: address{City: "Paris", Street: "Rue de Rivoli"}}
+
+	zero, inactive := 0, false
+	patch := profilePatch{Age: &zero, Active: &inactive, Address: &addressPatch{City: "Lyon"}}
+	assert.NoError(t, CopyWithOption(&stored, &patch, CopyOption{IgnoreEmpty: true}))
+	assert.Equal(t, profile{Name: "John", Age: 0, Active: false, Tags: []string{"a"},
+		Address: address{City: "Lyon", Street: "Rue de Rivoli"}}, stored)
+
+	// without IgnoreEmpty the nested struct and the name are replaced
+	assert.NoError(t, Copy(&stored, &patch))
+	assert.Equal(t, profile{Address: address{City: "Lyon"}}, stored)
+}
+
+func TestCopyWithOption_OverwriteEmpty(t *testing.T) {
+	stored := profile{Name: "John", Address: address{City: "Paris"}}
+	defaults := profile{Name: "Unknown", Age: 18, Tags: []string{"new"}, Address: address{City: "Lyon", Street: "Main"}}
+
+	assert.NoError(t, CopyWithOption(&stored, &defaults, CopyOption{Overwrite: OverwriteEmpty}))
+	assert.Equal(t, profile{Name: "John", Age: 18, Tags: []string{"new"},
+		Address: address{City: "Paris", Street: "Main"}}, stored)
+
+	patch := &profilePatch{Address: &addressPatch{}}
+	assert.NoError(t, CopyWithOption(patch, &stored, CopyOption{IgnoreEmpty: true, Overwrite: OverwriteEmpty}))
+	assert.Equal(t, "John", patch.Name)
+	assert.Equal(t, 18, *patch.Age)
+	assert.Nil(t, patch.Active)
+	assert.Equal(t, &addressPatch{City: "Paris", Street: "Main"}, patch.Address)
+}
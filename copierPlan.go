@@ -0,0 +1,385 @@
+package utils
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// copyPlan is how the fields of one struct type are copied into another, compiled once per type pair
+type copyPlan struct {
+	// err fails every copy, e.g. for ambiguous matches or must fields without a source
+	err    error
+	fields []fieldPlan
+	// getters fill destination fields from source methods, after the fields are copied
+	getters []getterPlan
+	// problems are reported by strict copies, e.g. source fields without a destination
+	problems []planProblem
+}
+
+// planProblem is a problem copying the source field name
+type planProblem struct {
+	name string
+	err  error
+}
+
+// fieldPlan copies a source field into a destination field, or into a destination setter method when to is nil
+type fieldPlan struct {
+	name string
+	from []int
+	to   []int
+	// direct fields have identical types whose values are assigned as is, unless per call converters are given
+	direct bool
+	// directPointer fields are pointers to identical types whose values are assigned as is
+	directPointer bool
+	// setterIndex is the index of the setter on the destination pointer and value, -1 when it has no such method
+	setterIndex [2]int
+}
+
+// getterPlan copies the result of a source method into a destination field
+type getterPlan struct {
+	name string
+	to   []int
+	// index of the method on the source pointer and value, -1 when it has no such method
+	index [2]int
+}
+
+// maxKeyedNormalizers is the number of field name normalizers a cached plan is keyed by,
+// plans of options with more normalizers are built on every copy
+const maxKeyedNormalizers = 4
+
+type copyPlanKey struct {
+	from        reflect.Type
+	to          reflect.Type
+	normalizers [maxKeyedNormalizers]uintptr
+}
+
+// copyPlans caches *copyPlan by copyPlanKey
+var copyPlans sync.Map
+
+// clearCopyPlans drops the cached plans, as registering converters changes them
+func clearCopyPlans() {
+	copyPlans.Range(func(key, _ interface{}) bool {
+		copyPlans.Delete(key)
+		return true
+	})
+}
+
+// copyPlanFor returns the cached plan of the struct types. Field name normalizers are identified by
+// their function, so closures of the same function are expected to normalize alike.
+func copyPlanFor(fromType reflect.Type, toType reflect.Type, opt CopyOption) *copyPlan {
+	if len(opt.FieldNameNormalizers) > maxKeyedNormalizers {
+		return buildCopyPlan(fromType, toType, opt)
+	}
+	key := copyPlanKey{from: fromType, to: toType}
+	for i, normalizer := range opt.FieldNameNormalizers {
+		key.normalizers[i] = reflect.ValueOf(normalizer).Pointer()
+	}
+	if plan, ok := copyPlans.Load(key); ok {
+		return plan.(*copyPlan)
+	}
+	plan, _ := copyPlans.LoadOrStore(key, buildCopyPlan(fromType, toType, opt))
+	return plan.(*copyPlan)
+}
+
+func buildCopyPlan(fromType reflect.Type, toType reflect.Type, opt CopyOption) *copyPlan {
+	plan := &copyPlan{}
+	// shadowed fields are never copied, as the field shadowing them is copied under the same name
+	fromFields, toFields := visibleFields(fromType), visibleFields(toType)
+	// copiedFrom tracks the source field of every destination field, to report ambiguous matches
+	copiedFrom := map[string]string{}
+
+	for _, field := range fromFields {
+		tag := parseCopierTag(field)
+		if tag.Ignore {
+			continue
+		}
+
+		toField, ok, err := matchField(field, fromFields, toType, toFields, opt)
+		if err != nil {
+			plan.err = err
+			return plan
+		}
+		if !ok {
+			// try to set to method
+			if setter, ok := newSetterPlan(field, toType, tag.CopyName(field.Name)); ok {
+				plan.fields = append(plan.fields, setter)
+			} else if hasSetter(toType, tag.CopyName(field.Name)) {
+				plan.problems = append(plan.problems, planProblem{field.Name,
+					fmt.Errorf("setter %s.%s does not take %s", toType.Name(), tag.CopyName(field.Name), field.Type)})
+			} else if field.IsExported() {
+				plan.problems = append(plan.problems, planProblem{field.Name,
+					fmt.Errorf("%s has no field or setter %q to copy into", toType.Name(), tag.CopyName(field.Name))})
+			}
+			continue
+		}
+		if parseCopierTag(toField).Ignore {
+			continue
+		}
+		if other, found := copiedFrom[toField.Name]; found {
+			plan.err = fmt.Errorf("ambiguous field match: %s.%s matches both %s and %s",
+				toType.Name(), toField.Name, other, field.Name)
+			return plan
+		}
+		copiedFrom[toField.Name] = field.Name
+
+		fp := fieldPlan{name: field.Name, from: field.Index, to: toField.Index}
+		if field.Type.Kind() == reflect.Ptr {
+			fp.directPointer = isDirectAssignable(field.Type, toField.Type) &&
+				isDirectAssignable(field.Type.Elem(), toField.Type.Elem()) &&
+				!field.Type.Implements(scannerType) && isScalarKind(field.Type.Elem().Kind())
+		} else {
+			fp.direct = isDirectAssignable(field.Type, toField.Type)
+		}
+		plan.fields = append(plan.fields, fp)
+	}
+
+	for _, field := range toFields {
+		tag := parseCopierTag(field)
+		if tag.Ignore || copiedFrom[field.Name] != "" {
+			continue
+		}
+		if getter, ok := newGetterPlan(fromType, toType, field, tag.CopyName(field.Name)); ok {
+			plan.getters = append(plan.getters, getter)
+		} else if tag.Must {
+			plan.err = fmt.Errorf("field %s.%s is tagged must but %s has no field or method %q to copy from",
+				toType.Name(), field.Name, fromType.Name(), tag.CopyName(field.Name))
+			return plan
+		}
+	}
+	return plan
+}
+
+// isDirectAssignable reports whether values are assigned as is, without any of the conversions of set
+func isDirectAssignable(fromType reflect.Type, toType reflect.Type) bool {
+	if fromType != toType {
+		return false
+	}
+	if kind := fromType.Kind(); kind == reflect.Interface || kind == reflect.Chan || kind == reflect.Func {
+		return false
+	}
+	_, ok := CopyOption{}.lookupConverter(fromType, toType)
+	return !ok
+}
+
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+func isScalarKind(kind reflect.Kind) bool {
+	return isSemanticKind(kind) || kind == reflect.Complex64 || kind == reflect.Complex128
+}
+
+// setterNames are the names of the methods setting the field name, e.g. Email(string) and SetEmail(string) error
+func setterNames(name string) []string {
+	return []string{name, "Set" + name}
+}
+
+func newSetterPlan(fromField reflect.StructField, toType reflect.Type, name string) (fieldPlan, bool) {
+	setter := fieldPlan{name: fromField.Name, from: fromField.Index, setterIndex: [2]int{-1, -1}}
+	for _, setterName := range setterNames(name) {
+		for i, receiver := range []reflect.Type{reflect.PtrTo(toType), toType} {
+			if method, ok := receiver.MethodByName(setterName); ok && method.Type.NumIn() == 2 &&
+				fromField.Type.AssignableTo(method.Type.In(1)) && returnsNothingOrError(method.Type, 0) {
+				setter.setterIndex[i] = method.Index
+			}
+		}
+		if setter.setterIndex != [2]int{-1, -1} {
+			return setter, true
+		}
+	}
+	return setter, false
+}
+
+func newGetterPlan(fromType reflect.Type, toType reflect.Type, field reflect.StructField, name string) (getterPlan, bool) {
+	toField, ok := toType.FieldByName(field.Name)
+	if !ok {
+		return getterPlan{}, false
+	}
+	getter := getterPlan{name: name, to: toField.Index, index: [2]int{-1, -1}}
+	for i, receiver := range []reflect.Type{reflect.PtrTo(fromType), fromType} {
+		if method, ok := receiver.MethodByName(name); ok && method.Type.NumIn() == 1 && method.Type.NumOut() >= 1 &&
+			returnsNothingOrError(method.Type, 1) {
+			getter.index[i] = method.Index
+		}
+	}
+	return getter, getter.index != [2]int{-1, -1}
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// returnsNothingOrError reports whether the method returns that many values, optionally followed by an error
+func returnsNothingOrError(method reflect.Type, values int) bool {
+	switch method.NumOut() {
+	case values:
+		return true
+	case values + 1:
+		return method.Out(values) == errorType
+	}
+	return false
+}
+
+// methodError is the error returned by a method, as its last result
+func methodError(results []reflect.Value) error {
+	if len(results) == 0 {
+		return nil
+	}
+	last := results[len(results)-1]
+	if last.Type() != errorType || last.IsNil() {
+		return nil
+	}
+	return last.Interface().(error)
+}
+
+// hasSetter reports whether the struct type has a method named like a setter of the field name
+func hasSetter(structType reflect.Type, name string) bool {
+	for _, setterName := range setterNames(name) {
+		if _, found := reflect.PtrTo(structType).MethodByName(setterName); found {
+			return true
+		}
+	}
+	return false
+}
+
+// planMethod returns the method of the value, bound to its address when it has one
+func planMethod(value reflect.Value, index [2]int) reflect.Value {
+	if value.CanAddr() {
+		if index[0] >= 0 {
+			return value.Addr().Method(index[0])
+		}
+		return reflect.Value{}
+	}
+	if index[1] >= 0 {
+		return value.Method(index[1])
+	}
+	return reflect.Value{}
+}
+
+// copyStruct copies the struct source into the struct dest following the plan
+func copyStruct(dest reflect.Value, source reflect.Value, plan *copyPlan, opt CopyOption) error {
+	if plan.err != nil {
+		return opt.fail(plan.err)
+	}
+	if opt.collected != nil {
+		for _, problem := range plan.problems {
+			// fields left out by the mask are not copied, so they have no problems
+			if _, masked := opt.masked(problem.name); masked {
+				_ = opt.field(problem.name).fail(problem.err)
+			}
+		}
+	}
+	fast := len(opt.Converters) == 0 && opt.Overwrite == OverwriteAlways
+
+	for i := range plan.fields {
+		field := &plan.fields[i]
+		maskOpt, masked := opt.masked(field.name)
+		if !masked {
+			continue
+		}
+		fromField, err := source.FieldByIndexErr(field.from)
+		if err != nil {
+			// embedded through a nil pointer
+			continue
+		}
+		if (field.direct || field.directPointer) && fast && maskOpt.mask == nil &&
+			!(opt.patching() && fromField.Kind() == reflect.Struct) {
+			if opt.IgnoreEmpty && fromField.IsZero() {
+				continue
+			}
+			if toField := fieldByIndexAlloc(dest, field.to); toField.CanSet() {
+				setDirect(toField, fromField, field.directPointer, opt)
+			}
+			continue
+		}
+
+		if opt.DeepCopy && field.to == nil {
+			// fields are cloned by set, which copies the pointers copied already, e.g. the root, into their copies
+			fromField = deepClone(fromField, opt.visits)
+		}
+		// an explicit pointer to a zero value is not empty, it sets the field to zero
+		if opt.IgnoreEmpty && fromField.IsZero() {
+			continue
+		}
+		if field.to == nil {
+			if toMethod := planMethod(dest, field.setterIndex); toMethod.IsValid() {
+				err = methodError(toMethod.Call([]reflect.Value{fromField}))
+				if err = opt.field(field.name).fail(err); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if toField := fieldByIndexAlloc(dest, field.to); toField.IsValid() {
+			fieldOpt := maskOpt.field(field.name)
+			if err = fieldOpt.fail(setWithField(toField, fromField, fieldOpt)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := range plan.getters {
+		getter := &plan.getters[i]
+		if _, masked := opt.masked(getter.name); !masked {
+			continue
+		}
+		fromMethod := planMethod(source, getter.index)
+		toField := fieldByIndexAlloc(dest, getter.to)
+		if !fromMethod.IsValid() || !toField.CanSet() {
+			continue
+		}
+		results := fromMethod.Call(nil)
+		fieldOpt := opt.field(getter.name)
+		if err := methodError(results[1:]); err != nil {
+			if err = fieldOpt.fail(err); err != nil {
+				return err
+			}
+			continue
+		}
+		value := results[0]
+		if (opt.IgnoreEmpty && value.IsZero()) || (opt.Overwrite == OverwriteEmpty && !toField.IsZero()) {
+			continue
+		}
+		if opt.DeepCopy {
+			value = deepClone(value, opt.visits)
+		}
+		if _, err := set(toField, value, fieldOpt); fieldOpt.fail(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setDirect assigns the value of an identical type the way set does, without looking for conversions
+func setDirect(toField reflect.Value, fromField reflect.Value, pointer bool, opt CopyOption) {
+	switch {
+	case !pointer:
+		if opt.DeepCopy {
+			fromField = deepClone(fromField, opt.visits)
+		}
+		toField.Set(fromField)
+	case fromField.IsNil():
+		toField.Set(reflect.Zero(toField.Type()))
+	default:
+		// like set, the value is copied into the pointer of the destination
+		if toField.IsNil() {
+			toField.Set(reflect.New(toField.Type().Elem()))
+		}
+		toField.Elem().Set(fromField.Elem())
+	}
+}
+
+// fieldByIndexAlloc returns the nested field, allocating the embedded pointers leading to it
+func fieldByIndexAlloc(value reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				if !value.CanSet() {
+					return reflect.Value{}
+				}
+				value.Set(reflect.New(value.Type().Elem()))
+			}
+			value = value.Elem()
+		}
+		value = value.Field(x)
+	}
+	return value
+}
@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type planBase struct {
+	ID int64
+}
+
+type planSource struct {
+	*planBase
+	Name  string
+	Score float64
+	Tags  []string
+}
+
+type planTarget struct {
+	planBase
+	Name  string
+	Score float64
+	Tags  []string
+}
+
+func TestCopyPlanFor_Cached(t *testing.T) {
+	fromType, toType := reflect.TypeOf(planSource{}), reflect.TypeOf(planTarget{})
+	plan := copyPlanFor(fromType, toType, CopyOption{})
+	assert.Same(t, plan, copyPlanFor(fromType, toType, CopyOption{}))
+	assert.NotSame(t, plan, copyPlanFor(fromType, toType, CopyOption{FieldNameNormalizers: []FieldNameNormalizer{MatchCaseInsensitive}}))
+	normalized := CopyOption{FieldNameNormalizers: []FieldNameNormalizer{MatchCaseInsensitive}}
+	assert.Same(t, copyPlanFor(fromType, toType, normalized), copyPlanFor(fromType, toType, normalized))
+	// plans of more normalizers than the key holds are not cached
+	normalized.FieldNameNormalizers = make([]FieldNameNormalizer, maxKeyedNormalizers+1)
+	for i := range normalized.FieldNameNormalizers {
+		normalized.FieldNameNormalizers[i] = MatchCaseInsensitive
+	}
+	assert.NotSame(t, copyPlanFor(fromType, toType, normalized), copyPlanFor(fromType, toType, normalized))
+	assert.Len(t, plan.fields, 4)
+	assert.True(t, plan.fields[1].direct)
+	assert.True(t, plan.fields[3].direct)
+
+	// registering a converter recompiles the plans using it
+	assert.NoError(t, RegisterConverter(TypeConverter{SrcType: "", DstType: "", Fn: func(src interface{}) (interface{}, error) {
+		return src.(string) + "!", nil
+	}}))
+	defer UnregisterConverter("", "")
+	assert.NotSame(t, plan, copyPlanFor(fromType, toType, CopyOption{}))
+
+	target := planTarget{}
+	assert.NoError(t, Copy(&target, &planSource{Name: "John"}))
+	assert.Equal(t, "John!", target.Name)
+}
+
+func TestCopyPlan_Concurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			targets := []planTarget{}
+			assert.NoError(t, Copy(&targets, []planSource{{planBase: &planBase{ID: int64(i)}, Name: strconv.Itoa(i)}, {}}))
+			assert.Equal(t, []planTarget{{planBase: planBase{ID: int64(i)}, Name: strconv.Itoa(i)}, {}}, targets)
+		}(i)
+	}
+	wg.Wait()
+}
+
+type benchmarkRow struct {
+	ID       int64
+	Name     string
+	Email    string
+	Active   bool
+	Score    float64
+	Created  time.Time
+	Nickname *string
+	Tags     []string
+}
+
+type benchmarkModel struct {
+	ID       int64
+	Name     string
+	Email    string
+	Active   bool
+	Score    float64
+	Created  time.Time
+	Nickname *string
+	Tags     []string
+}
+
+func benchmarkRows() []benchmarkRow {
+	nickname := "jd"
+	rows := make([]benchmarkRow, 1000)
+	for i := range rows {
+		rows[i] = benchmarkRow{ID: int64(i), Name: "John", Email: "john@example.org", Active: true, Score: 1.5,
+			Created: time.Now(), Nickname: &nickname, Tags: []string{"a"}}
+	}
+	return rows
+}
+
+func BenchmarkCopy_Slice(b *testing.B) {
+	rows := benchmarkRows()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		models := make([]benchmarkModel, 0, len(rows))
+		if err := Copy(&models, rows); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkCopy_ColdPlan copies a single row without a cached plan, as the first copy of a type pair does
+func BenchmarkCopy_ColdPlan(b *testing.B) {
+	rows := benchmarkRows()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		clearCopyPlans()
+		model := benchmarkModel{}
+		if err := Copy(&model, &rows[0]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCopy_SliceByHand(b *testing.B) {
+	rows := benchmarkRows()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		models := make([]benchmarkModel, 0, len(rows))
+		for _, row := range rows {
+			models = append(models, benchmarkModel{ID: row.ID, Name: row.Name, Email: row.Email, Active: row.Active,
+				Score: row.Score, Created: row.Created, Nickname: row.Nickname, Tags: row.Tags})
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package utils
 
 import (
+	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -493,3 +496,367 @@ func TestCopySimpleSet(t *testing.T) {
 		t.Errorf("Copy test failed, expected[%s], got[%s]", *src, *dest)
 	}
 }
+
+type UserDTO struct {
+	FullName string
+	Password string
+	Email    string `copier:"name=Mail"`
+	Age      int
+}
+
+type UserEntity struct {
+	Name     string `copier:"name=FullName,must"`
+	Password string `copier:"-"`
+	Mail     string
+	Years    int `copier:"name=Age"`
+}
+
+func TestCopyWithTags(t *testing.T) {
+	dto := UserDTO{FullName: "John Doe", Password: "secret", Email: "john@example.org", Age: 42}
+	entity := UserEntity{}
+	if err := Copy(&entity, &dto); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if entity.Name != dto.FullName {
+		t.Errorf("Name should be copied from FullName, got %q", entity.Name)
+	}
+	if entity.Password != "" {
+		t.Errorf("Password is ignored and should not be copied")
+	}
+	if entity.Mail != dto.Email {
+		t.Errorf("Mail should be copied from Email, got %q", entity.Mail)
+	}
+	if entity.Years != dto.Age {
+		t.Errorf("Years should be copied from Age, got %d", entity.Years)
+	}
+
+	reversed := UserDTO{}
+	if err := Copy(&reversed, &entity); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if reversed.FullName != dto.FullName || reversed.Email != dto.Email || reversed.Age != dto.Age || reversed.Password != "" {
+		t.Errorf("tags should apply when copying back, got %+v", reversed)
+	}
+}
+
+type UserSummary struct {
+	Name string `copier:"name=Nickname,must"`
+}
+
+func TestCopyMustFieldWithoutSource(t *testing.T) {
+	err := Copy(&UserEntity{}, &Employee{Name: "John"})
+	if err == nil || err.Error() != `field UserEntity.Name is tagged must but Employee has no field or method "FullName" to copy from` {
+		t.Errorf("missing must field should fail the copy, got %v", err)
+	}
+
+	summaries := []UserSummary{}
+	if err = Copy(&summaries, []User{{Nickname: "jd"}}); err != nil || summaries[0].Name != "jd" {
+		t.Errorf("must field with a source should be copied, got %v %+v", err, summaries)
+	}
+}
+
+type CacheEntry struct {
+	Key     string
+	Tags    []string
+	Meta    map[string][]int
+	Owner   *User
+	Slots   [2]*int
+	Payload interface{}
+	Parent  *CacheEntry
+}
+
+func TestCopyDeepCopy(t *testing.T) {
+	slot := 1
+	original := CacheEntry{
+		Key:     "a",
+		Tags:    []string{"x"},
+		Meta:    map[string][]int{"m": {1}},
+		Owner:   &User{Name: "John", Notes: []string{"note"}},
+		Slots:   [2]*int{&slot},
+		Payload: map[string]interface{}{"n": []interface{}{1}},
+		Parent:  &CacheEntry{Key: "p", Tags: []string{"y"}},
+	}
+
+	shallow := CacheEntry{}
+	if err := Copy(&shallow, &original); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if &shallow.Tags[0] != &original.Tags[0] {
+		t.Errorf("Copy without DeepCopy should keep sharing slices")
+	}
+
+	clone := CacheEntry{}
+	if err := CopyWithOption(&clone, &original, CopyOption{DeepCopy: true}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(clone, original) {
+		t.Fatalf("deep copy should equal the original, got %+v", clone)
+	}
+
+	clone.Tags[0] = "changed"
+	clone.Meta["m"][0] = 2
+	clone.Owner.Notes[0] = "changed"
+	*clone.Slots[0] = 2
+	clone.Payload.(map[string]interface{})["n"].([]interface{})[0] = 2
+	clone.Parent.Tags[0] = "changed"
+	if original.Tags[0] != "x" || original.Meta["m"][0] != 1 || original.Owner.Notes[0] != "note" || slot != 1 ||
+		original.Payload.(map[string]interface{})["n"].([]interface{})[0] != 1 || original.Parent.Tags[0] != "y" {
+		t.Errorf("mutating the deep copy should not change the original, got %+v", original)
+	}
+
+	notes := []string{"a"}
+	copied := []string{}
+	if err := CopyWithOption(&copied, notes, CopyOption{DeepCopy: true}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	copied[0] = "b"
+	if notes[0] != "a" {
+		t.Errorf("deep copy of a slice should not share its backing array")
+	}
+}
+
+type Money struct {
+	Cents int64
+}
+
+func (m Money) Value() (driver.Value, error) {
+	if m.Cents < 0 {
+		return nil, errors.New("negative amount")
+	}
+	return m.Cents, nil
+}
+
+type AccountRow struct {
+	ID       sql.NullInt64
+	Login    sql.NullTime
+	Nickname sql.NullString
+	Score    sql.NullFloat64
+	Balance  Money
+	Parent   *sql.NullInt64
+	Code     sql.NullInt64
+}
+
+type AccountModel struct {
+	ID       int64
+	Login    *time.Time
+	Nickname *string
+	Score    float64
+	Balance  int
+	Parent   *int
+	Code     sql.NullString
+}
+
+func TestCopyFromValuer(t *testing.T) {
+	login := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	row := AccountRow{
+		ID:       sql.NullInt64{Int64: 7, Valid: true},
+		Login:    sql.NullTime{Time: login, Valid: true},
+		Nickname: sql.NullString{String: "jd", Valid: true},
+		Score:    sql.NullFloat64{Float64: 1.5, Valid: true},
+		Balance:  Money{Cents: 250},
+		Parent:   &sql.NullInt64{Int64: 3, Valid: true},
+		Code:     sql.NullInt64{Int64: 42, Valid: true},
+	}
+	model := AccountModel{}
+	if err := Copy(&model, &row); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if model.ID != 7 || !model.Login.Equal(login) || *model.Nickname != "jd" || model.Score != 1.5 ||
+		model.Balance != 250 || *model.Parent != 3 || model.Code != (sql.NullString{String: "42", Valid: true}) {
+		t.Errorf("valuer sources should be unwrapped, got %+v", model)
+	}
+
+	// NULL sets pointers to nil and other fields to their zero value
+	if err := Copy(&model, &AccountRow{Parent: &sql.NullInt64{}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if model.ID != 0 || model.Login != nil || model.Nickname != nil || model.Score != 0 || model.Parent != nil ||
+		model.Code.Valid {
+		t.Errorf("NULL values should reset the fields, got %+v", model)
+	}
+
+	if err := Copy(&model, &AccountRow{Balance: Money{Cents: -1}}); err == nil || err.Error() != "negative amount" {
+		t.Errorf("valuer errors should fail the copy, got %v", err)
+	}
+
+	var id int64
+	if err := Copy(&id, sql.NullInt64{Int64: 9, Valid: true}); err != nil || id != 9 {
+		t.Errorf("top level valuer should be unwrapped, got %v %v", id, err)
+	}
+
+	// struct destinations are copied field by field, without calling Value
+	var copied struct{ Cents int }
+	if err := Copy(&copied, Money{Cents: -1}); err != nil || copied.Cents != -1 {
+		t.Errorf("valuer should be copied into a struct field by field, got %v %v", copied, err)
+	}
+}
+
+type TreeNode struct {
+	Name     string
+	Parent   *TreeNode
+	Children []*TreeNode
+}
+
+type TreeNodeDTO struct {
+	Name     string
+	Parent   *TreeNodeDTO
+	Children []*TreeNodeDTO
+}
+
+func newTree() *TreeNode {
+	root := &TreeNode{Name: "root"}
+	for _, name := range []string{"a", "b"} {
+		root.Children = append(root.Children, &TreeNode{Name: name, Parent: root})
+	}
+	return root
+}
+
+func TestCopyCyclicGraph(t *testing.T) {
+	root := newTree()
+
+	dto := &TreeNodeDTO{}
+	if err := Copy(dto, root); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(dto.Children) != 2 || dto.Children[1].Name != "b" {
+		t.Fatalf("children should be copied, got %+v", dto.Children)
+	}
+	if dto.Children[0].Parent != dto || dto.Children[1].Parent != dto {
+		t.Errorf("parent pointers should point at the copied root")
+	}
+
+	clone := &TreeNode{}
+	if err := CopyWithOption(clone, root, CopyOption{DeepCopy: true}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if clone.Children[0] == root.Children[0] || clone.Children[0].Parent != clone {
+		t.Errorf("deep copy should reproduce the cycle in the copy")
+	}
+
+	loop := TreeNode{Name: "loop"}
+	loop.Parent = &loop
+	loopClone := TreeNode{}
+	if err := CopyWithOption(&loopClone, &loop, CopyOption{DeepCopy: true}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if loopClone.Parent != &loopClone {
+		t.Errorf("deep copy of a self reference should point at the copy, got %p want %p", loopClone.Parent, &loopClone)
+	}
+	loopDTO := TreeNodeDTO{}
+	if err := CopyWithOption(&loopDTO, &loop, CopyOption{DeepCopy: true}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if loopDTO.Parent != &loopDTO {
+		t.Errorf("deep copy of a self reference into another type should point at the copy")
+	}
+	deepDTO := &TreeNodeDTO{}
+	if err := CopyWithOption(deepDTO, root, CopyOption{DeepCopy: true}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if deepDTO.Children[1].Parent != deepDTO {
+		t.Errorf("deep copy into another type should reproduce the cycle")
+	}
+
+	patched := &TreeNodeDTO{}
+	if err := CopyWithOption(patched, root, CopyOption{IgnoreEmpty: true}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if patched.Children[1].Parent != patched {
+		t.Errorf("patch copies should reproduce the cycle too")
+	}
+
+	tree := map[string]interface{}{}
+	if err := Copy(&tree, root); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	child := tree["Children"].([]interface{})[0].(map[string]interface{})
+	if child["Name"] != "a" || child["Parent"].(map[string]interface{})["Name"] != "root" {
+		t.Errorf("cyclic struct should be copied into maps, got %v", child)
+	}
+}
+
+type ListNode struct {
+	Value int
+	Next  *ListNode
+}
+
+type ListNodeDTO struct {
+	Value int64
+	Next  *ListNodeDTO
+}
+
+func TestCopySharedReferences(t *testing.T) {
+	// a circular list
+	first := &ListNode{Value: 1}
+	first.Next = &ListNode{Value: 2, Next: &ListNode{Value: 3, Next: first}}
+
+	dto := ListNodeDTO{}
+	if err := Copy(&dto, first); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if dto.Next.Next.Value != 3 || dto.Next.Next.Next.Value != 1 || dto.Next.Next.Next.Next != dto.Next {
+		t.Errorf("the circular list should be reproduced, got %+v", dto)
+	}
+
+	shared := &ListNode{Value: 9}
+	pair := struct{ Left, Right *ListNode }{shared, shared}
+	copied := struct{ Left, Right *ListNodeDTO }{}
+	if err := Copy(&copied, &pair); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if copied.Left != copied.Right || copied.Left.Value != 9 {
+		t.Errorf("shared references should stay shared, got %+v %+v", copied.Left, copied.Right)
+	}
+}
+
+type Email string
+
+type Contact struct {
+	email string
+}
+
+func (c Contact) Email() (Email, error) {
+	if !strings.Contains(c.email, "@") {
+		return "", errors.New("invalid email " + c.email)
+	}
+	return Email(c.email), nil
+}
+
+type ContactDTO struct {
+	Email string
+}
+
+type Subscriber struct {
+	email string
+}
+
+func (s *Subscriber) SetEmail(email string) error {
+	if email == "" {
+		return errors.New("email is required")
+	}
+	s.email = email
+	return nil
+}
+
+func TestCopyErrorReturningMethods(t *testing.T) {
+	dto := ContactDTO{}
+	if err := Copy(&dto, Contact{email: "john@example.org"}); err != nil || dto.Email != "john@example.org" {
+		t.Errorf("getter returning an error should be used, got %q %v", dto.Email, err)
+	}
+	if err := Copy(&dto, Contact{email: "john"}); err == nil || err.Error() != "invalid email john" {
+		t.Errorf("getter error should be returned, got %v", err)
+	}
+
+	subscriber := Subscriber{}
+	if err := Copy(&subscriber, &ContactDTO{Email: "anna@example.org"}); err != nil || subscriber.email != "anna@example.org" {
+		t.Errorf("setter returning an error should be used, got %q %v", subscriber.email, err)
+	}
+	if err := Copy(&subscriber, &ContactDTO{}); err == nil || err.Error() != "email is required" {
+		t.Errorf("setter error should be returned, got %v", err)
+	}
+
+	err := CopyWithOption(&[]Subscriber{}, []ContactDTO{{Email: "a@example.org"}, {}}, CopyOption{Strict: true})
+	if err == nil || err.Error() != "[1].Email: email is required" {
+		t.Errorf("strict copies should report setter errors with their path, got %v", err)
+	}
+}
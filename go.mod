@@ -5,6 +5,7 @@ go 1.20
 require (
 	github.com/elimity-com/scim v0.0.0-20220121082953-15165b1a61c8
 	github.com/labstack/gommon v0.3.1
+	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/scim2/filter-parser/v2 v2.2.0
 	github.com/stretchr/testify v1.8.0
 )
@@ -0,0 +1,81 @@
+// Package copierfields holds the rules matching struct fields shared by the reflective copier
+// and the copiergen command generating copy functions, so both copy the same fields.
+package copierfields
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Tag is the parsed `copier:"..."` struct tag, e.g. `copier:"name=FullName,must"` or `copier:"-"`
+type Tag struct {
+	// Name matches the field with fields and methods of that name on the other side
+	Name string
+	// Ignore skips the field entirely
+	Ignore bool
+	// Must fails the copy when the source has nothing to copy into the field
+	Must bool
+}
+
+// ParseTag parses the copier key of a struct tag
+func ParseTag(structTag reflect.StructTag) Tag {
+	tag := Tag{}
+	value, ok := structTag.Lookup("copier")
+	if !ok {
+		return tag
+	}
+	if value == "-" {
+		tag.Ignore = true
+		return tag
+	}
+	for _, option := range strings.Split(value, ",") {
+		option = strings.TrimSpace(option)
+		switch {
+		case option == "must":
+			tag.Must = true
+		case strings.HasPrefix(option, "name="):
+			tag.Name = strings.TrimPrefix(option, "name=")
+		}
+	}
+	return tag
+}
+
+// CopyName is the name the field called fieldName is matched by
+func (tag Tag) CopyName(fieldName string) string {
+	if tag.Name != "" {
+		return tag.Name
+	}
+	return fieldName
+}
+
+// Deep lists the fields of the struct type T depth first, replacing every embedded field by the fields
+// of its type. fieldsOf returns the fields of a type, none when it is no struct, and embedded returns
+// the type of an embedded field.
+func Deep[T, F any](t T, fieldsOf func(T) []F, embedded func(F) (T, bool)) []F {
+	var fields []F
+	for _, field := range fieldsOf(t) {
+		if embeddedType, ok := embedded(field); ok {
+			fields = append(fields, Deep(embeddedType, fieldsOf, embedded)...)
+		} else {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// Visible keeps the deep fields reachable by name, resolved by lookup: a field shadows the deeper
+// fields of the same name, and lookup reports false for fields ambiguous at the same depth.
+func Visible[F any](fields []F, name func(F) string, lookup func(string) (F, bool)) []F {
+	var visible []F
+	seen := map[string]bool{}
+	for _, field := range fields {
+		if seen[name(field)] {
+			continue
+		}
+		seen[name(field)] = true
+		if field, ok := lookup(name(field)); ok {
+			visible = append(visible, field)
+		}
+	}
+	return visible
+}
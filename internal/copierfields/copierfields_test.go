@@ -0,0 +1,60 @@
+package copierfields
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTag(t *testing.T) {
+	assert.Equal(t, Tag{}, ParseTag(`json:"name"`))
+	assert.Equal(t, Tag{Ignore: true}, ParseTag(`copier:"-"`))
+	assert.Equal(t, Tag{Name: "FullName", Must: true}, ParseTag(`copier:"name=FullName, must"`))
+
+	assert.Equal(t, "FullName", Tag{Name: "FullName"}.CopyName("Name"))
+	assert.Equal(t, "Name", Tag{}.CopyName("Name"))
+}
+
+type base struct {
+	ID   int
+	Name string
+}
+
+type derived struct {
+	base
+	ID    int
+	Email string
+}
+
+func TestDeepAndVisible(t *testing.T) {
+	fieldsOf := func(structType reflect.Type) []reflect.StructField {
+		fields := make([]reflect.StructField, structType.NumField())
+		for i := range fields {
+			fields[i] = structType.Field(i)
+		}
+		return fields
+	}
+	embedded := func(field reflect.StructField) (reflect.Type, bool) {
+		return field.Type, field.Anonymous
+	}
+	name := func(field reflect.StructField) string {
+		return field.Name
+	}
+	derivedType := reflect.TypeOf(derived{})
+
+	deep := Deep(derivedType, fieldsOf, embedded)
+	assert.Equal(t, []string{"ID", "Name", "ID", "Email"}, names(deep, name))
+
+	visible := Visible(deep, name, derivedType.FieldByName)
+	assert.Equal(t, []string{"ID", "Name", "Email"}, names(visible, name))
+	assert.Equal(t, []int{1}, visible[0].Index)
+}
+
+func names[F any](fields []F, name func(F) string) []string {
+	result := make([]string, len(fields))
+	for i, field := range fields {
+		result[i] = name(field)
+	}
+	return result
+}
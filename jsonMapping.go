@@ -0,0 +1,230 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/scim2/filter-parser/v2"
+)
+
+// jsonMappingSeparator separates the column from the JSON path in a MappingValue, e.g. "u.profile->$.nickName"
+const jsonMappingSeparator = "->"
+
+var jsonKeyRegexp = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+
+// NewJsonMappingValues maps an attribute into a JSON column.
+// path is a JSON path such as "$.name.givenName" or, for multi-valued attributes
+// stored as JSON arrays, "$[*].value" or "$.emails[*].value".
+func NewJsonMappingValues(column string, path string, dataType string, isSortable bool) MappingValues {
+	return MappingValues{
+		MappingValue: column + jsonMappingSeparator + path,
+		DataType:     dataType,
+		IsSortable:   isSortable,
+	}
+}
+
+// jsonMapping is a parsed JSON column mapping
+type jsonMapping struct {
+	column string
+	// arrayPath holds the keys leading to the JSON array, when the path iterates over one
+	arrayPath []string
+	array     bool
+	// path holds the keys inside the document, or inside each array element
+	path []string
+}
+
+func parseJsonMapping(mappingValue string) (jsonMapping, bool) {
+	column, path, found := strings.Cut(mappingValue, jsonMappingSeparator)
+	if !found {
+		return jsonMapping{}, false
+	}
+	jm, err := parseJsonPath(strings.TrimSpace(path))
+	if err != nil {
+		return jsonMapping{}, false
+	}
+	jm.column = strings.TrimSpace(column)
+	return jm, true
+}
+
+func parseJsonPath(path string) (jsonMapping, error) {
+	jm := jsonMapping{}
+	if !strings.HasPrefix(path, "$") {
+		return jm, errors.New("json path must start with $")
+	}
+	rest := path[1:]
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, "[*]"):
+			if jm.array {
+				return jm, errors.New("nested json arrays are not supported")
+			}
+			jm.array = true
+			jm.arrayPath = jm.path
+			jm.path = nil
+			rest = rest[3:]
+		case strings.HasPrefix(rest, "."):
+			key := rest[1:]
+			if i := strings.IndexAny(key, ".["); i >= 0 {
+				key = key[:i]
+			}
+			if !jsonKeyRegexp.MatchString(key) {
+				return jm, fmt.Errorf("invalid json key %q", key)
+			}
+			jm.path = append(jm.path, key)
+			rest = rest[1+len(key):]
+		default:
+			return jm, fmt.Errorf("invalid json path %q", path)
+		}
+	}
+	return jm, nil
+}
+
+func (jm jsonMapping) isArray() bool {
+	return jm.array
+}
+
+func (jm jsonMapping) sameArray(other jsonMapping) bool {
+	return jm.array && other.array && jm.column == other.column &&
+		strings.Join(jm.arrayPath, ".") == strings.Join(other.arrayPath, ".")
+}
+
+// scalarExpression extracts the mapped value from the column as a comparable SQL expression
+func (jm jsonMapping) scalarExpression(dialect SqlDialect, dataType string) string {
+	return jsonExtract(dialect, jm.column, jm.path, dataType)
+}
+
+// elementExpression extracts the mapped value from the array element bound by existsPrefix
+func (jm jsonMapping) elementExpression(dialect SqlDialect, dataType string) string {
+	if dialect == DialectPostgres {
+		return jsonExtract(dialect, "elem", jm.path, dataType)
+	}
+	return jsonExtract(dialect, "jt.elem", jm.path, dataType)
+}
+
+// existsPrefix opens an EXISTS sub query binding every element of the array
+func (jm jsonMapping) existsPrefix(dialect SqlDialect) string {
+	if dialect == DialectPostgres {
+		return fmt.Sprintf("EXISTS (SELECT 1 FROM jsonb_array_elements(%s) AS elem WHERE", postgresJson(jm.column, jm.arrayPath))
+	}
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM JSON_TABLE(%s, '%s[*]' COLUMNS (elem JSON PATH '$')) AS jt WHERE",
+		jm.column, mysqlJsonPath(jm.arrayPath))
+}
+
+// containsExpression tests whether any array element holds the value bound to the placeholder
+func (jm jsonMapping) containsExpression(dialect SqlDialect, dataType string, placeholder string) string {
+	if dialect == DialectPostgres {
+		candidate := placeholder + "::" + postgresType(dataType)
+		for i := len(jm.path) - 1; i >= 0; i-- {
+			candidate = fmt.Sprintf("jsonb_build_object('%s', %s)", jm.path[i], candidate)
+		}
+		return fmt.Sprintf("%s @> jsonb_build_array(%s)", postgresJson(jm.column, jm.arrayPath), candidate)
+	}
+
+	candidate := "JSON_ARRAY(" + placeholder + ")"
+	if len(jm.path) > 0 {
+		candidate = placeholder
+		for i := len(jm.path) - 1; i >= 0; i-- {
+			candidate = fmt.Sprintf("JSON_OBJECT('%s', %s)", jm.path[i], candidate)
+		}
+	}
+	return fmt.Sprintf("JSON_CONTAINS(%s, %s, '%s')", jm.column, candidate, mysqlJsonPath(jm.arrayPath))
+}
+
+// presentExpression tests whether any array element holds a value
+func (jm jsonMapping) presentExpression(dialect SqlDialect) string {
+	path := mysqlJsonPath(jm.arrayPath) + "[*]" + strings.TrimPrefix(mysqlJsonPath(jm.path), "$")
+	if dialect == DialectPostgres {
+		return fmt.Sprintf("jsonb_path_exists(%s, '%s')", jm.column, path)
+	}
+	return fmt.Sprintf("JSON_EXTRACT(%s, '%s') IS NOT NULL", jm.column, path)
+}
+
+// valuePathJsonScope returns the JSON array filtered by a value path, when its attributes are mapped into one
+func (sq *SqlQuery) valuePathJsonScope(parent string, valueFilter filter.Expression) (jsonMapping, bool) {
+	for {
+		switch v := valueFilter.(type) {
+		case *filter.LogicalExpression:
+			valueFilter = v.Left
+			continue
+		case *filter.AttributeExpression:
+			mapping, ok := sq.lookupAttributeMapping(parent, v.AttributePath)
+			if !ok {
+				return jsonMapping{}, false
+			}
+			jm, ok := parseJsonMapping(mapping.MappingValue)
+			return jm, ok && jm.isArray()
+		}
+		return jsonMapping{}, false
+	}
+}
+
+// buildJsonArrayExpression renders a filter on a multi-valued attribute stored in a JSON array
+func (sq *SqlQuery) buildJsonArrayExpression(jm jsonMapping, sqlFieldType string, operator string, compareValue interface{}, token string) {
+	var condition string
+	switch {
+	case operator == "pr":
+		condition = jm.presentExpression(sq.Dialect)
+	case compareValue == nil && operator == "eq":
+		condition = "NOT " + jm.presentExpression(sq.Dialect)
+	case compareValue == nil && operator == "ne":
+		condition = jm.presentExpression(sq.Dialect)
+	case operator == "eq":
+		condition = jm.containsExpression(sq.Dialect, sqlFieldType, sq.bind(compareValue))
+	case operator == "ne":
+		// a missing value is not equal to anything in SCIM
+		condition = fmt.Sprintf("NOT %s OR %s IS NULL", jm.containsExpression(sq.Dialect, sqlFieldType, sq.bind(compareValue)), jm.column)
+	default:
+		sqlOperator, ok := scimOpMap[operator]
+		if !ok {
+			sq.Error = fmt.Errorf("unsupported operator %q", operator)
+			return
+		}
+		sqlValue := compareValue
+		if valueWrapper, ok := matchingOpByScimOp[operator]; ok {
+			sqlValue = valueWrapper[0] + fmt.Sprint(compareValue) + valueWrapper[1]
+		}
+		condition = fmt.Sprintf("%s %s %s %s)", jm.existsPrefix(sq.Dialect), jm.elementExpression(sq.Dialect, sqlFieldType),
+			sqlOperator, sq.bind(sqlValue))
+	}
+	_, _ = sq.Filter.WriteString(fmt.Sprintf(" (%s) %s", condition, strings.ToUpper(token)))
+}
+
+func jsonExtract(dialect SqlDialect, target string, keys []string, dataType string) string {
+	if dialect == DialectPostgres {
+		expression := target + " #>> '{}'"
+		if len(keys) > 0 {
+			expression = postgresJson(target, keys[:len(keys)-1]) + "->>'" + keys[len(keys)-1] + "'"
+		}
+		if pgType := postgresType(dataType); pgType != "text" {
+			return fmt.Sprintf("(%s)::%s", expression, pgType)
+		}
+		return expression
+	}
+	return fmt.Sprintf("JSON_UNQUOTE(JSON_EXTRACT(%s, '%s'))", target, mysqlJsonPath(keys))
+}
+
+func mysqlJsonPath(keys []string) string {
+	if len(keys) == 0 {
+		return "$"
+	}
+	return "$." + strings.Join(keys, ".")
+}
+
+func postgresJson(target string, keys []string) string {
+	for _, key := range keys {
+		target += "->'" + key + "'"
+	}
+	return target
+}
+
+func postgresType(dataType string) string {
+	switch dataType {
+	case "int", "int64":
+		return "bigint"
+	case "bool":
+		return "boolean"
+	}
+	return "text"
+}
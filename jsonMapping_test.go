@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/scim2/filter-parser/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+var jsonMappings = map[filter.AttributePath]MappingValues{
+	filter.AttributePath{AttributeName: "userName"}: {"u.user_name", "string", true},
+	filter.AttributePath{AttributeName: "name", SubAttribute: StringPtr("givenName")}: NewJsonMappingValues(
+		"u.profile", "$.name.givenName", "string", true),
+	filter.AttributePath{AttributeName: "addresses", SubAttribute: StringPtr("locality")}: NewJsonMappingValues(
+		"u.addresses", "$[*].locality", "string", false),
+	filter.AttributePath{AttributeName: "addresses", SubAttribute: StringPtr("type")}: NewJsonMappingValues(
+		"u.addresses", "$[*].type", "string", false),
+	filter.AttributePath{AttributeName: "x509Certificates", SubAttribute: StringPtr("value")}: NewJsonMappingValues(
+		"u.profile", "$.certificates[*].value", "string", false),
+}
+
+func TestParseJsonPath(t *testing.T) {
+	jm, err := parseJsonPath("$.certificates[*].value")
+	assert.NoError(t, err)
+	assert.Equal(t, jsonMapping{arrayPath: []string{"certificates"}, array: true, path: []string{"value"}}, jm)
+
+	for _, invalid := range []string{"name", "$[*][*]", "$.na'me", "$..name"} {
+		_, err = parseJsonPath(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	_, ok := parseJsonMapping("u.user_name")
+	assert.False(t, ok)
+}
+
+func TestJsonMapping_Filters(t *testing.T) {
+	tests := []struct {
+		filter  string
+		dialect SqlDialect
+		want    string
+		args    []interface{}
+	}{
+		{`name.givenName eq "John"`, DialectMySQL,
+			`(JSON_UNQUOTE(JSON_EXTRACT(u.profile, '$.name.givenName')) = ?)`, []interface{}{"John"}},
+		{`name.givenName sw "J"`, DialectPostgres,
+			`(u.profile->'name'->>'givenName' LIKE $1)`, []interface{}{"J%"}},
+		{`addresses.locality eq "Paris"`, DialectMySQL,
+			`(JSON_CONTAINS(u.addresses, JSON_OBJECT('locality', ?), '$'))`, []interface{}{"Paris"}},
+		{`addresses.locality eq "Paris"`, DialectPostgres,
+			`(u.addresses @> jsonb_build_array(jsonb_build_object('locality', $1::text)))`, []interface{}{"Paris"}},
+		{`addresses.locality ne "Paris"`, DialectPostgres,
+			`(NOT u.addresses @> jsonb_build_array(jsonb_build_object('locality', $1::text)) OR u.addresses IS NULL)`, []interface{}{"Paris"}},
+		{`x509Certificates.value pr`, DialectMySQL,
+			`(JSON_EXTRACT(u.profile, '$.certificates[*].value') IS NOT NULL)`, []interface{}{}},
+		{`x509Certificates.value pr`, DialectPostgres,
+			`(jsonb_path_exists(u.profile, '$.certificates[*].value'))`, []interface{}{}},
+		{`addresses.locality co "ar"`, DialectMySQL,
+			`(EXISTS (SELECT 1 FROM JSON_TABLE(u.addresses, '$[*]' COLUMNS (elem JSON PATH '$')) AS jt WHERE JSON_UNQUOTE(JSON_EXTRACT(jt.elem, '$.locality')) LIKE ?))`,
+			[]interface{}{"%ar%"}},
+		{`addresses[type eq "work" and locality sw "Par"]`, DialectPostgres,
+			`(EXISTS (SELECT 1 FROM jsonb_array_elements(u.addresses) AS elem WHERE ( (elem->>'type' = $1) AND (elem->>'locality' LIKE $2) ) ))`,
+			[]interface{}{"work", "Par%"}},
+		{`userName eq "jdoe" and x509Certificates[value co "MII"]`, DialectMySQL,
+			`( (u.user_name = ?) AND (EXISTS (SELECT 1 FROM JSON_TABLE(u.profile, '$.certificates[*]' COLUMNS (elem JSON PATH '$')) AS jt WHERE (JSON_UNQUOTE(JSON_EXTRACT(jt.elem, '$.value')) LIKE ?) )) )`,
+			[]interface{}{"jdoe", "%MII%"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.dialect)+" "+tt.filter, func(t *testing.T) {
+			expression, err := filter.ParseFilter([]byte(tt.filter))
+			assert.NoError(t, err)
+			got, err := ParseScimParamsWithDialect(scim.ListRequestParams{Filter: expression}, jsonMappings, "", "", tt.dialect)
+			assert.NoError(t, err)
+			query, args, err := got.Select(SqlSelect{Table: "users u"})
+			assert.NoError(t, err)
+			assert.Equal(t, "SELECT * FROM users u WHERE "+tt.want, strings.Split(query, " limit ")[0])
+			assert.Equal(t, tt.args, args)
+		})
+	}
+}
+
+func TestJsonMapping_ValuePathMixedColumns(t *testing.T) {
+	expression, err := filter.ParseFilter([]byte(`addresses[type eq "work" and value eq "x"]`))
+	assert.NoError(t, err)
+	mappings := map[filter.AttributePath]MappingValues{
+		filter.AttributePath{AttributeName: "addresses", SubAttribute: StringPtr("type")}: NewJsonMappingValues(
+			"u.addresses", "$[*].type", "string", false),
+		filter.AttributePath{AttributeName: "addresses", SubAttribute: StringPtr("value")}: {"u.address", "string", false},
+	}
+	_, err = ParseScimParams(scim.ListRequestParams{Filter: expression}, mappings, "", "")
+	assert.Error(t, err)
+}
+
+func TestJsonMapping_SortBy(t *testing.T) {
+	params := ScimListParams{SortBy: "name.givenName", SortOrder: ScimSortAscending}
+	got, err := params.SqlQueryWithDialect(jsonMappings, "u.id", DialectPostgres)
+	assert.NoError(t, err)
+	assert.Equal(t, "order by u.profile->'name'->>'givenName' asc", got.OrderBy)
+}
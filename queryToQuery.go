@@ -12,10 +12,21 @@ import (
 
 type MappingValues struct {
 	MappingValue string
-	DataType     string
-	IsSortable   bool
+	// DataType is one of int, int64, bool, string or array (a JSON array column)
+	DataType   string
+	IsSortable bool
 }
 
+// SqlDialect selects the SQL flavour rendered by SqlQuery
+type SqlDialect string
+
+const (
+	// DialectMySQL const
+	DialectMySQL SqlDialect = "mysql"
+	// DialectPostgres const
+	DialectPostgres SqlDialect = "postgres"
+)
+
 var scimOpMap = map[string]string{
 	"eq": "=",
 	"ne": "!=",
@@ -35,6 +46,22 @@ var matchingOpByScimOp = map[string][]string{
 	"ew": {"%", ""},
 }
 
+// SqlJoin describes a single JOIN clause rendered by SqlQuery.Select
+type SqlJoin struct {
+	// Type is the join type (INNER, LEFT, RIGHT, ...), defaults to INNER
+	Type  string
+	Table string
+	On    string
+}
+
+// SqlSelect holds the table, joins and columns of a SELECT statement
+type SqlSelect struct {
+	Table string
+	// Columns to select, defaults to *
+	Columns []string
+	Joins   []SqlJoin
+}
+
 type SqlQuery struct {
 	fieldMappings map[filter.AttributePath]MappingValues
 	// Filter should be a pointer
@@ -45,15 +72,22 @@ type SqlQuery struct {
 	requestId  string
 	Limit      string
 	OrderBy    string
+	Dialect    SqlDialect
+	// jsonScope is the JSON array whose elements are filtered by the value path being rendered
+	jsonScope *jsonMapping
 }
 
 func ParseScimParams(params scim.ListRequestParams, fieldMappings map[filter.AttributePath]MappingValues, orderField string, orderDirection string) (*SqlQuery, error) {
+	return ParseScimParamsWithDialect(params, fieldMappings, orderField, orderDirection, DialectMySQL)
+}
 
-	offsetFactor := 1
+// ParseScimParamsWithDialect works as ParseScimParams, rendering the filter and limit for the given dialect
+func ParseScimParamsWithDialect(params scim.ListRequestParams, fieldMappings map[filter.AttributePath]MappingValues, orderField string, orderDirection string, dialect SqlDialect) (*SqlQuery, error) {
+
+	// StartIndex is the 1-based page, 0 and 1 both select the first page
 	offset := 0
-	if params.StartIndex > 0 {
-		offsetFactor = params.StartIndex
-		offset = offsetFactor * params.Count
+	if params.StartIndex > 1 {
+		offset = (params.StartIndex - 1) * params.Count
 	}
 
 	sqlQuery := &SqlQuery{
@@ -61,7 +95,13 @@ func ParseScimParams(params scim.ListRequestParams, fieldMappings map[filter.Att
 		Error:         nil,
 		fieldMappings: fieldMappings,
 		Limit:         fmt.Sprintf("limit %s, %s", strconv.Itoa(offset), strconv.Itoa(params.Count)),
-		OrderBy:       fmt.Sprintf("order by %s %s", orderField, orderDirection),
+		Dialect:       dialect,
+	}
+	if dialect == DialectPostgres {
+		sqlQuery.Limit = fmt.Sprintf("limit %s offset %s", strconv.Itoa(params.Count), strconv.Itoa(offset))
+	}
+	if orderField != "" {
+		sqlQuery.OrderBy = strings.TrimSpace(fmt.Sprintf("order by %s %s", orderField, orderDirection))
 	}
 
 	if params.Filter == nil {
@@ -83,6 +123,94 @@ func (sq *SqlQuery) GetParameterList() []interface{} {
 	return params
 }
 
+// Select renders the complete parameterized SELECT statement for the given table configuration
+// together with the ordered argument list, ready to be passed to db.QueryContext
+func (sq *SqlQuery) Select(sel SqlSelect) (string, []interface{}, error) {
+	if sq.Error != nil {
+		return "", nil, sq.Error
+	}
+	if sel.Table == "" {
+		return "", nil, errors.New("no table supplied for select")
+	}
+
+	columns := "*"
+	if len(sel.Columns) > 0 {
+		columns = strings.Join(sel.Columns, ", ")
+	}
+
+	query := &strings.Builder{}
+	_, _ = query.WriteString(fmt.Sprintf("SELECT %s FROM %s", columns, sel.Table))
+	for _, join := range sel.Joins {
+		if join.Table == "" || join.On == "" {
+			return "", nil, fmt.Errorf("invalid join supplied for table %q", join.Table)
+		}
+		joinType := "INNER"
+		if join.Type != "" {
+			joinType = strings.ToUpper(join.Type)
+		}
+		_, _ = query.WriteString(fmt.Sprintf(" %s JOIN %s ON %s", joinType, join.Table, join.On))
+	}
+	if sq.Filter != nil {
+		// the filter builder pads every expression with spaces, collapse them
+		if where := strings.Join(strings.Fields(sq.Filter.String()), " "); where != "" {
+			_, _ = query.WriteString(" WHERE " + where)
+		}
+	}
+	if sq.OrderBy != "" {
+		_, _ = query.WriteString(" " + sq.OrderBy)
+	}
+	if sq.Limit != "" {
+		_, _ = query.WriteString(" " + sq.Limit)
+	}
+
+	return query.String(), sq.GetParameterList(), nil
+}
+
+// SelectCount renders the parameterized statement counting every row matching the filter,
+// used for the totalResults of paginated list responses
+func (sq *SqlQuery) SelectCount(sel SqlSelect) (string, []interface{}, error) {
+	paged := *sq
+	paged.OrderBy, paged.Limit = "", ""
+	sel.Columns = []string{"COUNT(*)"}
+	return paged.Select(sel)
+}
+
+// bind adds a parameter, returning the placeholder of the dialect to render for it
+func (sq *SqlQuery) bind(value interface{}) string {
+	sq.Parameters[len(sq.Parameters)] = value
+	if sq.Dialect == DialectPostgres {
+		return "$" + strconv.Itoa(len(sq.Parameters))
+	}
+	return "?"
+}
+
+// rebindPostgres replaces every ? of the query by the positional $n placeholders used by Postgres,
+// so it must only be used on queries without literal or operator question marks
+func rebindPostgres(query string) string {
+	rebound := &strings.Builder{}
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			_, _ = rebound.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		_, _ = rebound.WriteRune(r)
+	}
+	return rebound.String()
+}
+
+// lookupMapping finds the mapping of the given attribute path, ignoring the URI prefix and case
+func lookupMapping(fieldMappings map[filter.AttributePath]MappingValues, attrPath filter.AttributePath) (MappingValues, bool) {
+	for k, v := range fieldMappings {
+		if strings.EqualFold(k.AttributeName, attrPath.AttributeName) &&
+			strings.EqualFold(k.SubAttributeName(), attrPath.SubAttributeName()) {
+			return v, true
+		}
+	}
+	return MappingValues{}, false
+}
+
 func (sq *SqlQuery) visitList(pFilter interface{}) (*SqlQuery, error) {
 
 	switch v := pFilter.(type) {
@@ -91,7 +219,7 @@ func (sq *SqlQuery) visitList(pFilter interface{}) (*SqlQuery, error) {
 	case *filter.AttributeExpression:
 		sq.buildAttributeExpression("", pFilter.(*filter.AttributeExpression), " ")
 	case *filter.ValuePath:
-		sq.buildValuePathExpression(pFilter.(*filter.ValuePath))
+		sq.buildValuePathExpression(pFilter.(*filter.ValuePath), " ")
 	default:
 		sq.Error = errors.New(fmt.Sprintf("I don't know about type %T!", v))
 	}
@@ -101,12 +229,26 @@ func (sq *SqlQuery) visitList(pFilter interface{}) (*SqlQuery, error) {
 	return sq, nil
 }
 
-func (sq *SqlQuery) buildValuePathExpression(pFilter *filter.ValuePath) {
+func (sq *SqlQuery) buildValuePathExpression(pFilter *filter.ValuePath, token string) {
+	parent := pFilter.AttributePath.AttributeName
+	if sq.jsonScope == nil {
+		// value filters over a JSON array must match within a single element
+		if scope, ok := sq.valuePathJsonScope(parent, pFilter.ValueFilter); ok {
+			sq.jsonScope = &scope
+			_, _ = sq.Filter.WriteString(" (" + scope.existsPrefix(sq.Dialect))
+			sq.buildValuePathExpression(pFilter, " ")
+			_, _ = sq.Filter.WriteString(")) " + strings.ToUpper(token))
+			sq.jsonScope = nil
+			return
+		}
+	}
+
 	switch pFilter.ValueFilter.(type) {
 	case *filter.LogicalExpression:
-		sq.buildLogicalExpression(pFilter.String(), pFilter.ValueFilter.(*filter.LogicalExpression))
+		sq.buildLogicalExpression(parent, pFilter.ValueFilter.(*filter.LogicalExpression))
+		_, _ = sq.Filter.WriteString(" " + strings.ToUpper(token) + " ")
 	case *filter.AttributeExpression:
-		sq.buildAttributeExpression(pFilter.String(), pFilter.ValueFilter.(*filter.AttributeExpression), " ")
+		sq.buildAttributeExpression(parent, pFilter.ValueFilter.(*filter.AttributeExpression), token)
 	}
 }
 
@@ -119,6 +261,8 @@ func (sq *SqlQuery) buildLogicalExpression(parent string, pFilter *filter.Logica
 		_, _ = sq.Filter.WriteString(" " + string(pFilter.Operator) + " ")
 	case *filter.AttributeExpression:
 		sq.buildAttributeExpression(parent, pFilter.Left.(*filter.AttributeExpression), string(pFilter.Operator))
+	case *filter.ValuePath:
+		sq.buildValuePathExpression(pFilter.Left.(*filter.ValuePath), string(pFilter.Operator))
 	}
 	if sq.Error != nil {
 		return
@@ -129,6 +273,8 @@ func (sq *SqlQuery) buildLogicalExpression(parent string, pFilter *filter.Logica
 		sq.buildLogicalExpression(parent, pFilter.Right.(*filter.LogicalExpression))
 	case *filter.AttributeExpression:
 		sq.buildAttributeExpression(parent, pFilter.Right.(*filter.AttributeExpression), " ")
+	case *filter.ValuePath:
+		sq.buildValuePathExpression(pFilter.Right.(*filter.ValuePath), " ")
 	}
 	_, _ = sq.Filter.WriteString(" ) ")
 }
@@ -137,26 +283,47 @@ func (sq *SqlQuery) buildAttributeExpression(parent string, pFilter *filter.Attr
 	sqlField, sqlFieldType, sqlOperator := "", "", ""
 	var sqlValue interface{}
 	// 1. find sql field
-	for k, v := range sq.fieldMappings {
-		if (parent == k.AttributeName && *k.SubAttribute == pFilter.AttributePath.AttributeName) ||
-			(k.AttributeName == pFilter.AttributePath.AttributeName &&
-				k.SubAttribute == pFilter.AttributePath.SubAttribute) {
-
-			sqlField = v.MappingValue
-			sqlFieldType = v.DataType
-			break
-		}
+	if mapping, ok := sq.lookupAttributeMapping(parent, pFilter.AttributePath); ok {
+		sqlField = mapping.MappingValue
+		sqlFieldType = mapping.DataType
 	}
 	if sqlField == "" {
 		log.Print(sq.requestId, "Invalid field supplied\n")
 		sq.Error = errors.New("invalid/unmapped field supplied")
 		return
 	}
-	sqlOperator = scimOpMap[strings.ToLower(string(pFilter.Operator))]
+	operator := strings.ToLower(string(pFilter.Operator))
 
-	valueWrapper, ok := matchingOpByScimOp[strings.ToLower(string(pFilter.Operator))]
+	// 2. resolve json column mappings
+	if jm, ok := parseJsonMapping(sqlField); ok || sq.jsonScope != nil {
+		switch {
+		case sq.jsonScope != nil:
+			if !ok || !jm.sameArray(*sq.jsonScope) {
+				sq.Error = errors.New("value path attributes must be mapped to the same json array")
+				return
+			}
+			sqlField = jm.elementExpression(sq.Dialect, sqlFieldType)
+		case jm.isArray():
+			sq.buildJsonArrayExpression(jm, sqlFieldType, operator, pFilter.CompareValue, token)
+			return
+		default:
+			sqlField = jm.scalarExpression(sq.Dialect, sqlFieldType)
+		}
+	}
+	sqlOperator = scimOpMap[operator]
 
-	if string(pFilter.Operator) != "pr" {
+	valueWrapper, ok := matchingOpByScimOp[operator]
+
+	if operator == "pr" {
+		_, _ = sq.Filter.WriteString(fmt.Sprintf(" (%s) %s", sq.presentCondition(sqlField, sqlFieldType), strings.ToUpper(token)))
+	} else if pFilter.CompareValue == nil && (operator == "eq" || operator == "ne") {
+		// comparing to null tests for absence (eq) or presence (ne) of the value
+		nullCheck := "IS NULL"
+		if operator == "ne" {
+			nullCheck = "IS NOT NULL"
+		}
+		_, _ = sq.Filter.WriteString(fmt.Sprintf(" (%s %s) %s", sqlField, nullCheck, strings.ToUpper(token)))
+	} else {
 		if !ok {
 			// need to check type here
 			switch sqlFieldType {
@@ -173,10 +340,36 @@ func (sq *SqlQuery) buildAttributeExpression(parent string, pFilter *filter.Attr
 		} else {
 			sqlValue = valueWrapper[0] + pFilter.CompareValue.(string) + valueWrapper[1]
 		}
-		_, _ = sq.Filter.WriteString(fmt.Sprintf(" (%s %s ?) %s", sqlField, sqlOperator, strings.ToUpper(token)))
-		sq.Parameters[len(sq.Parameters)] = sqlValue
-	} else {
-		_, _ = sq.Filter.WriteString(fmt.Sprintf(" (%s %s) %s", sqlField, sqlOperator, strings.ToUpper(token)))
+		placeholder := sq.bind(sqlValue)
+		if operator == "ne" {
+			// a missing value is not equal to anything in SCIM
+			_, _ = sq.Filter.WriteString(fmt.Sprintf(" (%s %s %s OR %s IS NULL) %s", sqlField, sqlOperator, placeholder, sqlField, strings.ToUpper(token)))
+		} else {
+			_, _ = sq.Filter.WriteString(fmt.Sprintf(" (%s %s %s) %s", sqlField, sqlOperator, placeholder, strings.ToUpper(token)))
+		}
 	}
 
 }
+
+// lookupAttributeMapping finds the mapping of an attribute, which is a sub-attribute of parent inside value paths
+func (sq *SqlQuery) lookupAttributeMapping(parent string, attrPath filter.AttributePath) (MappingValues, bool) {
+	if parent != "" {
+		subAttribute := attrPath.AttributeName
+		attrPath = filter.AttributePath{AttributeName: parent, SubAttribute: &subAttribute}
+	}
+	return lookupMapping(sq.fieldMappings, attrPath)
+}
+
+// presentCondition renders the SCIM pr operator, empty strings and empty multi-valued attributes are not present
+func (sq *SqlQuery) presentCondition(sqlField string, sqlFieldType string) string {
+	switch sqlFieldType {
+	case "string":
+		return fmt.Sprintf("%s IS NOT NULL AND %s <> ''", sqlField, sqlField)
+	case "array":
+		if sq.Dialect == DialectPostgres {
+			return fmt.Sprintf("%s IS NOT NULL AND jsonb_array_length(%s) > 0", sqlField, sqlField)
+		}
+		return fmt.Sprintf("%s IS NOT NULL AND JSON_LENGTH(%s) > 0", sqlField, sqlField)
+	}
+	return fmt.Sprintf("%s %s", sqlField, scimOpMap["pr"])
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/elimity-com/scim"
 	"github.com/scim2/filter-parser/v2"
 	"github.com/stretchr/testify/assert"
+	"strings"
 	"testing"
 )
 
@@ -132,3 +133,114 @@ func TestProcessor_GetParameterList(t *testing.T) {
 
 	assert.Equal(t, []interface{}{"0", "1", "2"}, got)
 }
+
+func TestProcessor_Select(t *testing.T) {
+	var Mappings = map[filter.AttributePath]MappingValues{
+		filter.AttributePath{AttributeName: "id"}:   {"tra.id", "int", true},
+		filter.AttributePath{AttributeName: "cost"}: {"tra.cost", "int", true},
+	}
+	expression, err := filter.ParseFilter([]byte(`cost eq 300`))
+	listRequestParams := scim.ListRequestParams{
+		Filter:     expression,
+		Count:      10,
+		StartIndex: 0,
+	}
+	got, err := ParseScimParams(listRequestParams, Mappings, "tra.id", "asc")
+	assert.NoError(t, err)
+
+	query, args, err := got.Select(SqlSelect{
+		Table:   "transactions tra",
+		Columns: []string{"tra.id", "tra.cost", "m.name"},
+		Joins:   []SqlJoin{{Type: "left", Table: "merchants m", On: "m.id = tra.merchant_id"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT tra.id, tra.cost, m.name FROM transactions tra LEFT JOIN merchants m ON m.id = tra.merchant_id "+
+		"WHERE (tra.cost = ?) order by tra.id asc limit 0, 10", query)
+	assert.Equal(t, []interface{}{300}, args)
+}
+
+func TestProcessor_Select_NoFilter(t *testing.T) {
+	got, err := ParseScimParams(scim.ListRequestParams{Count: 5}, nil, "", "")
+	assert.NoError(t, err)
+
+	query, args, err := got.Select(SqlSelect{Table: "transactions"})
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM transactions limit 0, 5", query)
+	assert.Empty(t, args)
+
+	_, _, err = got.Select(SqlSelect{})
+	assert.Error(t, err)
+	_, _, err = got.Select(SqlSelect{Table: "transactions", Joins: []SqlJoin{{Table: "merchants"}}})
+	assert.Error(t, err)
+}
+
+func TestProcessor_GetSqlQuery_NullSemantics(t *testing.T) {
+	var Mappings = map[filter.AttributePath]MappingValues{
+		filter.AttributePath{AttributeName: "id"}:     {"u.id", "int", true},
+		filter.AttributePath{AttributeName: "title"}:  {"u.title", "string", true},
+		filter.AttributePath{AttributeName: "emails"}: {"u.emails", "array", false},
+	}
+	tests := []struct {
+		filter  string
+		dialect SqlDialect
+		want    string
+		args    []interface{}
+	}{
+		{`title ne "CEO"`, DialectMySQL, "(u.title != ? OR u.title IS NULL)", []interface{}{"CEO"}},
+		{`title pr`, DialectMySQL, "(u.title IS NOT NULL AND u.title <> '')", []interface{}{}},
+		{`id pr`, DialectMySQL, "(u.id IS NOT NULL)", []interface{}{}},
+		{`emails pr`, DialectMySQL, "(u.emails IS NOT NULL AND JSON_LENGTH(u.emails) > 0)", []interface{}{}},
+		{`emails pr`, DialectPostgres, "(u.emails IS NOT NULL AND jsonb_array_length(u.emails) > 0)", []interface{}{}},
+		{`title eq null`, DialectMySQL, "(u.title IS NULL)", []interface{}{}},
+		{`title ne null`, DialectMySQL, "(u.title IS NOT NULL)", []interface{}{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			expression, err := filter.ParseFilter([]byte(tt.filter))
+			assert.NoError(t, err)
+			got, err := ParseScimParamsWithDialect(scim.ListRequestParams{Filter: expression}, Mappings, "", "", tt.dialect)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, strings.TrimSpace(got.Filter.String()))
+			assert.Equal(t, tt.args, got.GetParameterList())
+		})
+	}
+}
+
+func TestProcessor_Select_Postgres(t *testing.T) {
+	var Mappings = map[filter.AttributePath]MappingValues{
+		filter.AttributePath{AttributeName: "title"}: {"u.title", "string", true},
+		filter.AttributePath{AttributeName: "id"}:    {"u.id", "int", true},
+	}
+	expression, err := filter.ParseFilter([]byte(`title ne "CEO" and id gt 5`))
+	assert.NoError(t, err)
+	got, err := ParseScimParamsWithDialect(scim.ListRequestParams{Filter: expression, Count: 10}, Mappings, "u.id", "desc", DialectPostgres)
+	assert.NoError(t, err)
+
+	query, args, err := got.Select(SqlSelect{Table: "users u"})
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM users u WHERE ( (u.title != $1 OR u.title IS NULL) AND (u.id > $2) ) order by u.id desc limit 10 offset 0", query)
+	assert.Equal(t, []interface{}{"CEO", 5}, args)
+}
+
+func TestProcessor_Select_PostgresKeepsQuestionMarks(t *testing.T) {
+	var Mappings = map[filter.AttributePath]MappingValues{
+		filter.AttributePath{AttributeName: "title"}: {"u.title", "string", true},
+	}
+	expression, err := filter.ParseFilter([]byte(`title eq "CEO"`))
+	assert.NoError(t, err)
+	got, err := ParseScimParamsWithDialect(scim.ListRequestParams{Filter: expression, Count: 10}, Mappings, "", "", DialectPostgres)
+	assert.NoError(t, err)
+
+	query, args, err := got.Select(SqlSelect{
+		Table:   "users u",
+		Columns: []string{"u.id", "u.tags ? 'admin' AS admin"},
+		Joins:   []SqlJoin{{Table: "groups g", On: "g.id = u.group_id AND g.name != '?'"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT u.id, u.tags ? 'admin' AS admin FROM users u INNER JOIN groups g ON g.id = u.group_id AND g.name != '?' WHERE (u.title = $1) limit 10 offset 0", query)
+	assert.Equal(t, []interface{}{"CEO"}, args)
+
+	query, _, err = got.SelectCount(SqlSelect{Table: "users u", Joins: []SqlJoin{{Table: "groups g", On: "g.name != '?'"}}})
+	assert.NoError(t, err)
+	assert.Contains(t, query, "g.name != '?' WHERE (u.title = $1)")
+}
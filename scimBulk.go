@@ -0,0 +1,475 @@
+package utils
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/elimity-com/scim"
+	scimErrors "github.com/elimity-com/scim/errors"
+	"github.com/scim2/filter-parser/v2"
+)
+
+const (
+	// ScimBulkRequestSchema const
+	ScimBulkRequestSchema = "urn:ietf:params:scim:api:messages:2.0:BulkRequest"
+	// ScimBulkResponseSchema const
+	ScimBulkResponseSchema = "urn:ietf:params:scim:api:messages:2.0:BulkResponse"
+	// ScimPatchOpSchema const
+	ScimPatchOpSchema = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+
+	bulkIdPrefix = "bulkId:"
+)
+
+// ScimBulkRequest is the body of a SCIM POST /Bulk request (RFC 7644 §3.7)
+type ScimBulkRequest struct {
+	Schemas      []string            `json:"schemas"`
+	FailOnErrors int                 `json:"failOnErrors,omitempty"`
+	Operations   []ScimBulkOperation `json:"Operations"`
+}
+
+// ScimBulkOperation is a single operation of a bulk request
+type ScimBulkOperation struct {
+	Method  string                 `json:"method"`
+	BulkID  string                 `json:"bulkId,omitempty"`
+	Version string                 `json:"version,omitempty"`
+	Path    string                 `json:"path"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+}
+
+// ScimBulkResponse is the body of a SCIM bulk response
+type ScimBulkResponse struct {
+	Schemas    []string                  `json:"schemas"`
+	Operations []ScimBulkOperationResult `json:"Operations"`
+}
+
+// ScimBulkOperationResult is the outcome of a single bulk operation
+type ScimBulkOperationResult struct {
+	Method   string      `json:"method"`
+	BulkID   string      `json:"bulkId,omitempty"`
+	Version  string      `json:"version,omitempty"`
+	Location string      `json:"location,omitempty"`
+	Status   string      `json:"status"`
+	Response interface{} `json:"response,omitempty"`
+}
+
+// ScimTxHandler is implemented by resource handlers able to run inside a database transaction
+type ScimTxHandler interface {
+	BindTx(tx *sql.Tx) scim.ResourceHandler
+}
+
+// BindTx binds the handler to the transaction of a bulk request
+func (h *SqlResourceHandler) BindTx(tx *sql.Tx) scim.ResourceHandler {
+	return h.WithTx(tx)
+}
+
+// ScimBulkProcessor runs SCIM bulk requests against the resource handlers of each endpoint
+type ScimBulkProcessor struct {
+	// Handlers by resource endpoint, e.g. "/Users"
+	Handlers map[string]scim.ResourceHandler
+	// DB, when set, runs the operations inside one transaction, provided every handler implements ScimTxHandler.
+	// Every operation runs in its own savepoint so a failed one is undone without aborting the others,
+	// the operations succeeding before failOnErrors is reached are committed
+	DB *sql.DB
+	// MaxOperations and MaxPayloadSize limit the bulk requests accepted, zero means unlimited
+	MaxOperations  int
+	MaxPayloadSize int
+}
+
+// ServeHTTP handles POST /Bulk requests
+func (p ScimBulkProcessor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	response, err := p.Process(r)
+	if err != nil {
+		scimErr := scimErrors.CheckScimError(err, http.MethodPost)
+		w.Header().Set(ContentTypeHeader, "application/scim+json")
+		w.WriteHeader(scimErr.Status)
+		_ = json.NewEncoder(w).Encode(scimErr)
+		return
+	}
+	w.Header().Set(ContentTypeHeader, "application/scim+json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// Process validates the bulk request, runs its operations and builds the bulk response
+func (p ScimBulkProcessor) Process(r *http.Request) (*ScimBulkResponse, error) {
+	body, err := GetRequestBody(r)
+	if err != nil {
+		return nil, scimErrors.ScimErrorInvalidSyntax
+	}
+	if p.MaxPayloadSize > 0 && len(body) > p.MaxPayloadSize {
+		return nil, scimErrors.ScimError{
+			Detail: fmt.Sprintf("The size of the bulk operation exceeds the maxPayloadSize (%d).", p.MaxPayloadSize),
+			Status: http.StatusRequestEntityTooLarge,
+		}
+	}
+
+	var request ScimBulkRequest
+	if err = json.Unmarshal(body, &request); err != nil {
+		return nil, scimErrors.ScimErrorInvalidSyntax
+	}
+	if err = p.validate(request); err != nil {
+		return nil, err
+	}
+
+	handlers := p.Handlers
+	var tx *sql.Tx
+	if p.DB != nil && p.supportsTx() {
+		if tx, err = p.DB.BeginTx(r.Context(), nil); err != nil {
+			return nil, err
+		}
+		handlers = make(map[string]scim.ResourceHandler, len(p.Handlers))
+		for endpoint, handler := range p.Handlers {
+			handlers[endpoint] = handler.(ScimTxHandler).BindTx(tx)
+		}
+	}
+
+	run := &bulkRun{
+		request:  r,
+		tx:       tx,
+		handlers: handlers,
+		baseURL:  scimBaseURL(r),
+		bulkIds:  map[string]string{},
+		results:  make([]*ScimBulkOperationResult, len(request.Operations)),
+	}
+	run.execute(request)
+
+	if tx != nil {
+		if run.err != nil {
+			_ = tx.Rollback()
+			return nil, run.err
+		}
+		if err = tx.Commit(); err != nil {
+			_ = tx.Rollback()
+			return nil, err
+		}
+	}
+
+	response := &ScimBulkResponse{Schemas: []string{ScimBulkResponseSchema}, Operations: []ScimBulkOperationResult{}}
+	for _, result := range run.results {
+		if result != nil {
+			response.Operations = append(response.Operations, *result)
+		}
+	}
+	return response, nil
+}
+
+func (p ScimBulkProcessor) validate(request ScimBulkRequest) error {
+	if len(request.Schemas) != 1 || request.Schemas[0] != ScimBulkRequestSchema {
+		return scimErrors.ScimErrorBadRequest(fmt.Sprintf("schemas must be [%q]", ScimBulkRequestSchema))
+	}
+	if p.MaxOperations > 0 && len(request.Operations) > p.MaxOperations {
+		return scimErrors.ScimError{
+			Detail: fmt.Sprintf("The number of operations exceeds the maxOperations (%d).", p.MaxOperations),
+			Status: http.StatusRequestEntityTooLarge,
+		}
+	}
+
+	bulkIds := map[string]bool{}
+	for i, operation := range request.Operations {
+		method := strings.ToUpper(operation.Method)
+		switch method {
+		case http.MethodPost:
+			if operation.BulkID == "" {
+				return scimErrors.ScimErrorBadRequest(fmt.Sprintf("operation %d: bulkId is required for POST", i))
+			}
+		case http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			return scimErrors.ScimErrorBadRequest(fmt.Sprintf("operation %d: unsupported method %q", i, operation.Method))
+		}
+		if operation.BulkID != "" {
+			if bulkIds[operation.BulkID] {
+				return scimErrors.ScimErrorBadRequest(fmt.Sprintf("operation %d: duplicate bulkId %q", i, operation.BulkID))
+			}
+			bulkIds[operation.BulkID] = true
+		}
+		if _, _, err := p.route(operation); err != nil {
+			return scimErrors.ScimErrorBadRequest(fmt.Sprintf("operation %d: %v", i, err))
+		}
+	}
+	return nil
+}
+
+// route splits the operation path into its endpoint and resource id
+func (p ScimBulkProcessor) route(operation ScimBulkOperation) (string, string, error) {
+	path := "/" + strings.Trim(operation.Path, "/")
+	endpoint, id := path, ""
+	if i := strings.Index(path[1:], "/"); i >= 0 {
+		endpoint, id = path[:i+1], path[i+2:]
+	}
+	if _, ok := p.Handlers[endpoint]; !ok {
+		return "", "", fmt.Errorf("unknown endpoint %q", endpoint)
+	}
+	isPost := strings.EqualFold(operation.Method, http.MethodPost)
+	if isPost != (id == "") {
+		return "", "", fmt.Errorf("invalid path %q for %s", operation.Path, strings.ToUpper(operation.Method))
+	}
+	return endpoint, id, nil
+}
+
+func (p ScimBulkProcessor) supportsTx() bool {
+	for _, handler := range p.Handlers {
+		if _, ok := handler.(ScimTxHandler); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// bulkRun holds the state of a single bulk request
+type bulkRun struct {
+	request  *http.Request
+	tx       *sql.Tx
+	handlers map[string]scim.ResourceHandler
+	baseURL  string
+	// bulkIds maps the bulkId of every created resource to its id
+	bulkIds map[string]string
+	results []*ScimBulkOperationResult
+	errors  int
+	// err is set when a savepoint of the transaction fails, the bulk request can not go on then
+	err error
+}
+
+// execute runs the operations in order, deferring those referencing resources created by later operations.
+// It stops when failOnErrors is reached or a savepoint fails.
+func (b *bulkRun) execute(request ScimBulkRequest) {
+	pending := make([]int, len(request.Operations))
+	for i := range pending {
+		pending[i] = i
+	}
+
+	for len(pending) > 0 {
+		var deferred []int
+		for _, i := range pending {
+			operation := request.Operations[i]
+			if unresolved := b.unresolvedReferences(operation); len(unresolved) > 0 {
+				deferred = append(deferred, i)
+				continue
+			}
+			b.results[i] = b.runInSavepoint(i, operation)
+			if b.err != nil || b.failed(b.results[i], request.FailOnErrors) {
+				return
+			}
+		}
+		if len(deferred) == len(pending) {
+			// nothing could be resolved any more, the references are circular or point to failed operations
+			for _, i := range deferred {
+				b.results[i] = bulkError(request.Operations[i], scimErrors.ScimError{
+					ScimType: scimErrors.ScimTypeInvalidValue,
+					Detail:   fmt.Sprintf("unresolvable bulkId references %v", b.unresolvedReferences(request.Operations[i])),
+					Status:   http.StatusConflict,
+				})
+				if b.failed(b.results[i], request.FailOnErrors) {
+					return
+				}
+			}
+			return
+		}
+		pending = deferred
+	}
+}
+
+func (b *bulkRun) failed(result *ScimBulkOperationResult, failOnErrors int) bool {
+	if isBulkError(result) {
+		b.errors++
+	}
+	return failOnErrors > 0 && b.errors >= failOnErrors
+}
+
+func isBulkError(result *ScimBulkOperationResult) bool {
+	status, _ := strconv.Atoi(result.Status)
+	return status >= http.StatusBadRequest
+}
+
+// runInSavepoint runs the operation inside a savepoint of the transaction, rolling back to it when the operation fails
+func (b *bulkRun) runInSavepoint(i int, operation ScimBulkOperation) *ScimBulkOperationResult {
+	if b.tx == nil {
+		return b.run(operation)
+	}
+	ctx := b.request.Context()
+	savepoint := "bulk_operation_" + strconv.Itoa(i)
+	if _, b.err = b.tx.ExecContext(ctx, "SAVEPOINT "+savepoint); b.err != nil {
+		return nil
+	}
+	result := b.run(operation)
+	statement := "RELEASE SAVEPOINT "
+	if isBulkError(result) {
+		statement = "ROLLBACK TO SAVEPOINT "
+	}
+	_, b.err = b.tx.ExecContext(ctx, statement+savepoint)
+	return result
+}
+
+// unresolvedReferences returns the bulkIds referenced by the operation which are not created yet,
+// references to unknown bulkIds never resolve
+func (b *bulkRun) unresolvedReferences(operation ScimBulkOperation) []string {
+	var unresolved []string
+	for _, reference := range bulkReferences(operation.Path, operation.Data) {
+		if _, ok := b.bulkIds[reference]; !ok {
+			unresolved = append(unresolved, reference)
+		}
+	}
+	return unresolved
+}
+
+func (b *bulkRun) run(operation ScimBulkOperation) *ScimBulkOperationResult {
+	path := b.resolve(operation.Path).(string)
+	data, _ := b.resolve(operation.Data).(map[string]interface{})
+
+	method := strings.ToUpper(operation.Method)
+	endpoint, id, _ := ScimBulkProcessor{Handlers: b.handlers}.route(ScimBulkOperation{Method: method, Path: path})
+	handler := b.handlers[endpoint]
+
+	r, err := http.NewRequestWithContext(b.request.Context(), method, path, nil)
+	if err != nil {
+		return bulkError(operation, scimErrors.ScimErrorInvalidPath)
+	}
+	r.Header = b.request.Header.Clone()
+	r.Header.Del("If-Match")
+	if operation.Version != "" {
+		r.Header.Set("If-Match", operation.Version)
+	}
+
+	var resource scim.Resource
+	status := http.StatusOK
+	switch method {
+	case http.MethodPost:
+		status = http.StatusCreated
+		resource, err = handler.Create(r, bulkAttributes(data))
+		if err == nil {
+			b.bulkIds[operation.BulkID] = resource.ID
+		}
+	case http.MethodPut:
+		resource, err = handler.Replace(r, id, bulkAttributes(data))
+	case http.MethodPatch:
+		var operations []scim.PatchOperation
+		if operations, err = bulkPatchOperations(data); err == nil {
+			resource, err = handler.Patch(r, id, operations)
+		}
+	case http.MethodDelete:
+		status = http.StatusNoContent
+		err = handler.Delete(r, id)
+	}
+	if err != nil {
+		return bulkError(operation, scimErrors.CheckScimError(err, method))
+	}
+
+	result := &ScimBulkOperationResult{
+		Method:  method,
+		BulkID:  operation.BulkID,
+		Version: resource.Meta.Version,
+		Status:  strconv.Itoa(status),
+	}
+	if method != http.MethodDelete {
+		result.Location = b.baseURL + endpoint + "/" + resource.ID
+	}
+	return result
+}
+
+// resolve replaces every bulkId reference by the id of the resource created for it
+func (b *bulkRun) resolve(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		for bulkId, id := range b.bulkIds {
+			if strings.HasSuffix(v, bulkIdPrefix+bulkId) {
+				return strings.TrimSuffix(v, bulkIdPrefix+bulkId) + id
+			}
+		}
+		return v
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			resolved[key] = b.resolve(elem)
+		}
+		return resolved
+	case []interface{}:
+		resolved := make([]interface{}, len(v))
+		for i, elem := range v {
+			resolved[i] = b.resolve(elem)
+		}
+		return resolved
+	}
+	return value
+}
+
+// bulkReferences collects the bulkIds referenced in the path and data of an operation
+func bulkReferences(values ...interface{}) []string {
+	var references []string
+	for _, value := range values {
+		switch v := value.(type) {
+		case string:
+			if i := strings.Index(v, bulkIdPrefix); i >= 0 {
+				references = append(references, v[i+len(bulkIdPrefix):])
+			}
+		case map[string]interface{}:
+			for _, elem := range v {
+				references = append(references, bulkReferences(elem)...)
+			}
+		case []interface{}:
+			references = append(references, bulkReferences(v...)...)
+		}
+	}
+	return references
+}
+
+func bulkAttributes(data map[string]interface{}) scim.ResourceAttributes {
+	attributes := scim.ResourceAttributes{}
+	for key, value := range data {
+		if key != "schemas" {
+			attributes[key] = value
+		}
+	}
+	return attributes
+}
+
+// bulkPatchOperations decodes the PatchOp message of a bulk PATCH operation
+func bulkPatchOperations(data map[string]interface{}) ([]scim.PatchOperation, error) {
+	if schemas, _ := lookupAttribute(data, "schemas").([]interface{}); len(schemas) != 1 || schemas[0] != ScimPatchOpSchema {
+		return nil, scimErrors.ScimErrorBadRequest(fmt.Sprintf("schemas must be [%q]", ScimPatchOpSchema))
+	}
+	rawOperations, _ := lookupAttribute(data, "Operations").([]interface{})
+	if len(rawOperations) == 0 {
+		return nil, scimErrors.ScimErrorInvalidSyntax
+	}
+	operations := make([]scim.PatchOperation, 0, len(rawOperations))
+	for _, rawOperation := range rawOperations {
+		values, _ := rawOperation.(map[string]interface{})
+		op, _ := lookupAttribute(values, "op").(string)
+		operation := scim.PatchOperation{Op: strings.ToLower(op), Value: lookupAttribute(values, "value")}
+		switch operation.Op {
+		case scim.PatchOperationAdd, scim.PatchOperationReplace, scim.PatchOperationRemove:
+		default:
+			return nil, scimErrors.ScimErrorInvalidSyntax
+		}
+		if path, _ := lookupAttribute(values, "path").(string); path != "" {
+			parsed, err := filter.ParsePath([]byte(path))
+			if err != nil {
+				return nil, scimErrors.ScimErrorInvalidPath
+			}
+			operation.Path = &parsed
+		}
+		operations = append(operations, operation)
+	}
+	return operations, nil
+}
+
+func bulkError(operation ScimBulkOperation, err scimErrors.ScimError) *ScimBulkOperationResult {
+	return &ScimBulkOperationResult{
+		Method:   strings.ToUpper(operation.Method),
+		BulkID:   operation.BulkID,
+		Status:   strconv.Itoa(err.Status),
+		Response: err,
+	}
+}
+
+// scimBaseURL derives the base URL of the SCIM service from the bulk request, e.g. https://example.com/scim/v2
+func scimBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	path := strings.TrimSuffix(strings.TrimSuffix(r.URL.Path, "/"), "/Bulk")
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, path)
+}
@@ -0,0 +1,207 @@
+package utils
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	scimErrors "github.com/elimity-com/scim/errors"
+	"github.com/scim2/filter-parser/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBulkProcessor(t *testing.T) (ScimBulkProcessor, *SqlResourceHandler, *SqlResourceHandler) {
+	db, users := newTestUserHandler(t)
+	_, err := db.Exec(`CREATE TABLE groups (id TEXT PRIMARY KEY, display_name TEXT NOT NULL, members TEXT)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, err := NewSqlResourceHandler(db, ScimResourceMapping{
+		Table:    "groups",
+		IDColumn: "id",
+		Attributes: map[filter.AttributePath]MappingValues{
+			filter.AttributePath{AttributeName: "displayName"}: {"display_name", "string", true},
+			filter.AttributePath{AttributeName: "members"}:     NewJsonMappingValues("members", "$", "string", false),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	processor := ScimBulkProcessor{
+		Handlers: map[string]scim.ResourceHandler{"/Users": users, "/Groups": groups},
+		DB:       db,
+	}
+	return processor, users, groups
+}
+
+func bulkRequest(body string) *http.Request {
+	return httptest.NewRequest(http.MethodPost, "https://example.com/scim/v2/Bulk", bytes.NewBufferString(body))
+}
+
+func TestScimBulkProcessor_BulkIdReferences(t *testing.T) {
+	processor, users, groups := newTestBulkProcessor(t)
+
+	// the group is listed first but references the user created after it
+	response, err := processor.Process(bulkRequest(`{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:BulkRequest"],
+		"Operations": [
+			{"method": "POST", "path": "/Groups", "bulkId": "admins",
+			 "data": {"displayName": "Admins", "members": [{"type": "User", "value": "bulkId:jdoe"}]}},
+			{"method": "POST", "path": "/Users", "bulkId": "jdoe", "data": {"userName": "jdoe"}},
+			{"method": "PATCH", "path": "/Users/bulkId:jdoe", "data": {
+				"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+				"Operations": [{"op": "replace", "path": "active", "value": true}]}}
+		]
+	}`))
+	assert.NoError(t, err)
+	assert.Len(t, response.Operations, 3)
+
+	group, user := response.Operations[0], response.Operations[1]
+	assert.Equal(t, "201", group.Status)
+	assert.Equal(t, "201", user.Status)
+	assert.Equal(t, "200", response.Operations[2].Status)
+	assert.Equal(t, `W/"2"`, response.Operations[2].Version)
+	assert.Regexp(t, "^https://example.com/scim/v2/Users/", user.Location)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	page, err := users.GetAll(r, scim.ListRequestParams{StartIndex: 1, Count: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, true, page.Resources[0].Attributes["active"])
+
+	created, err := groups.Get(r, group.Location[len("https://example.com/scim/v2/Groups/"):])
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{map[string]interface{}{"type": "User", "value": page.Resources[0].ID}},
+		created.Attributes["members"])
+}
+
+func TestScimBulkProcessor_FailOnErrors(t *testing.T) {
+	processor, users, _ := newTestBulkProcessor(t)
+
+	response, err := processor.Process(bulkRequest(`{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:BulkRequest"],
+		"failOnErrors": 1,
+		"Operations": [
+			{"method": "POST", "path": "/Users", "bulkId": "a", "data": {"userName": "jdoe"}},
+			{"method": "DELETE", "path": "/Users/unknown"},
+			{"method": "POST", "path": "/Users", "bulkId": "b", "data": {"userName": "asmith"}}
+		]
+	}`))
+	assert.NoError(t, err)
+	assert.Len(t, response.Operations, 2)
+	assert.Equal(t, "201", response.Operations[0].Status)
+	assert.Equal(t, "404", response.Operations[1].Status)
+	assert.NotNil(t, response.Operations[1].Response)
+
+	// the operations succeeding before failOnErrors is reached are kept, as reported
+	page, err := users.GetAll(httptest.NewRequest(http.MethodGet, "/", nil), scim.ListRequestParams{StartIndex: 1, Count: 10})
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, page.TotalResults) {
+		assert.Equal(t, "https://example.com/scim/v2/Users/"+page.Resources[0].ID, response.Operations[0].Location)
+	}
+}
+
+func TestScimBulkProcessor_FailOnErrorsUnresolvable(t *testing.T) {
+	processor, _, groups := newTestBulkProcessor(t)
+
+	response, err := processor.Process(bulkRequest(`{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:BulkRequest"],
+		"failOnErrors": 1,
+		"Operations": [
+			{"method": "POST", "path": "/Groups", "bulkId": "a", "data": {"displayName": "A"}},
+			{"method": "POST", "path": "/Groups", "bulkId": "b", "data": {"displayName": "B", "members": [{"value": "bulkId:c"}]}},
+			{"method": "POST", "path": "/Groups", "bulkId": "c", "data": {"displayName": "C", "members": [{"value": "bulkId:b"}]}}
+		]
+	}`))
+	assert.NoError(t, err)
+	assert.Len(t, response.Operations, 2)
+	assert.Equal(t, "201", response.Operations[0].Status)
+	assert.Equal(t, "409", response.Operations[1].Status)
+
+	page, err := groups.GetAll(httptest.NewRequest(http.MethodGet, "/", nil), scim.ListRequestParams{StartIndex: 1, Count: 10})
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, page.TotalResults) {
+		assert.Equal(t, "https://example.com/scim/v2/Groups/"+page.Resources[0].ID, response.Operations[0].Location)
+	}
+}
+
+// failingCreateHandler creates the resource and then fails, leaving the insert for the savepoint to undo
+type failingCreateHandler struct {
+	scim.ResourceHandler
+}
+
+func (h failingCreateHandler) BindTx(tx *sql.Tx) scim.ResourceHandler {
+	return failingCreateHandler{ResourceHandler: h.ResourceHandler.(ScimTxHandler).BindTx(tx)}
+}
+
+func (h failingCreateHandler) Create(r *http.Request, attributes scim.ResourceAttributes) (scim.Resource, error) {
+	if _, err := h.ResourceHandler.Create(r, attributes); err != nil {
+		return scim.Resource{}, err
+	}
+	return scim.Resource{}, scimErrors.ScimError{Detail: "rejected after insert", Status: http.StatusConflict}
+}
+
+func TestScimBulkProcessor_Savepoints(t *testing.T) {
+	processor, users, groups := newTestBulkProcessor(t)
+	processor.Handlers["/Groups"] = failingCreateHandler{ResourceHandler: groups}
+
+	response, err := processor.Process(bulkRequest(`{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:BulkRequest"],
+		"Operations": [
+			{"method": "POST", "path": "/Groups", "bulkId": "g", "data": {"displayName": "Admins"}},
+			{"method": "POST", "path": "/Users", "bulkId": "u", "data": {"userName": "jdoe"}}
+		]
+	}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "409", response.Operations[0].Status)
+	assert.Equal(t, "201", response.Operations[1].Status)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	page, err := groups.GetAll(r, scim.ListRequestParams{StartIndex: 1, Count: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, page.TotalResults)
+	page, err = users.GetAll(r, scim.ListRequestParams{StartIndex: 1, Count: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, page.TotalResults)
+}
+
+func TestScimBulkProcessor_CircularReferences(t *testing.T) {
+	processor, _, _ := newTestBulkProcessor(t)
+
+	response, err := processor.Process(bulkRequest(`{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:BulkRequest"],
+		"Operations": [
+			{"method": "POST", "path": "/Groups", "bulkId": "a", "data": {"displayName": "A", "members": [{"value": "bulkId:b"}]}},
+			{"method": "POST", "path": "/Groups", "bulkId": "b", "data": {"displayName": "B", "members": [{"value": "bulkId:a"}]}}
+		]
+	}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "409", response.Operations[0].Status)
+	assert.Equal(t, "409", response.Operations[1].Status)
+}
+
+func TestScimBulkProcessor_Invalid(t *testing.T) {
+	processor, _, _ := newTestBulkProcessor(t)
+	processor.MaxOperations = 1
+
+	for _, body := range []string{
+		`{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": []}`,
+		`{"schemas": ["urn:ietf:params:scim:api:messages:2.0:BulkRequest"], "Operations": [{"method": "POST", "path": "/Users"}]}`,
+		`{"schemas": ["urn:ietf:params:scim:api:messages:2.0:BulkRequest"], "Operations": [{"method": "DELETE", "path": "/Things/1"}]}`,
+		`{"schemas": ["urn:ietf:params:scim:api:messages:2.0:BulkRequest"], "Operations": [{"method": "GET", "path": "/Users/1"}]}`,
+	} {
+		_, err := processor.Process(bulkRequest(body))
+		assert.Error(t, err, body)
+	}
+
+	w := httptest.NewRecorder()
+	processor.ServeHTTP(w, bulkRequest(`{"schemas": ["urn:ietf:params:scim:api:messages:2.0:BulkRequest"], "Operations": [
+		{"method": "DELETE", "path": "/Users/1"}, {"method": "DELETE", "path": "/Users/2"}]}`))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "413", response["status"])
+}
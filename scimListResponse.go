@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ScimListResponseSchema const
+const ScimListResponseSchema = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+
+// scimAlwaysReturned are the attributes returned regardless of attributes/excludedAttributes
+var scimAlwaysReturned = []string{"id", "schemas"}
+
+// ScimListResponse is the SCIM ListResponse envelope (RFC 7644 §3.4.2)
+type ScimListResponse struct {
+	Schemas      []string                 `json:"schemas"`
+	TotalResults int                      `json:"totalResults"`
+	ItemsPerPage int                      `json:"itemsPerPage"`
+	StartIndex   int                      `json:"startIndex"`
+	Resources    []map[string]interface{} `json:"Resources"`
+}
+
+// BuildScimListResponse builds the ListResponse for one page of query results.
+// rows must be a slice of structs or maps, which are converted to their JSON representation
+// and trimmed according to the attributes/excludedAttributes of the list params.
+func BuildScimListResponse(rows interface{}, totalResults int, params ScimListParams) (*ScimListResponse, error) {
+	resources, err := toScimResources(rows)
+	if err != nil {
+		return nil, err
+	}
+	// a zero Count asks for totalResults only (RFC 7644 §3.4.2.4), the parsers default it to their maxCount
+	if params.Count >= 0 && len(resources) > params.Count {
+		resources = resources[:params.Count]
+	}
+	for i, resource := range resources {
+		resources[i] = trimScimAttributes(resource, params.Attributes, params.ExcludedAttributes)
+	}
+
+	startIndex := params.StartIndex
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	return &ScimListResponse{
+		Schemas:      []string{ScimListResponseSchema},
+		TotalResults: totalResults,
+		ItemsPerPage: len(resources),
+		StartIndex:   startIndex,
+		Resources:    resources,
+	}, nil
+}
+
+func toScimResources(rows interface{}) ([]map[string]interface{}, error) {
+	resources := make([]map[string]interface{}, 0)
+	if IsNil(rows) {
+		return resources, nil
+	}
+	value := Indirect(reflect.ValueOf(rows))
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return nil, errors.New("rows must be a slice")
+	}
+
+	for i := 0; i < value.Len(); i++ {
+		// round-trip through JSON so json tags apply and rows are never mutated
+		raw, err := json.Marshal(value.Index(i).Interface())
+		if err != nil {
+			return nil, fmt.Errorf("error encoding row %d: %v", i, err)
+		}
+		var resource map[string]interface{}
+		if err = json.Unmarshal(raw, &resource); err != nil || resource == nil {
+			return nil, fmt.Errorf("row %d is not a json object", i)
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
+
+// trimScimAttributes applies the attributes/excludedAttributes parameters to a resource
+func trimScimAttributes(resource map[string]interface{}, attributes []string, excludedAttributes []string) map[string]interface{} {
+	if len(attributes) > 0 {
+		trimmed := make(map[string]interface{})
+		for _, name := range scimAlwaysReturned {
+			if key, ok := scimKey(resource, name); ok {
+				trimmed[key] = resource[key]
+			}
+		}
+		for _, attribute := range attributes {
+			includeScimPath(trimmed, resource, scimAttributePath(resource, attribute))
+		}
+		resource = trimmed
+	}
+	for _, attribute := range excludedAttributes {
+		path := scimAttributePath(resource, attribute)
+		if len(path) == 1 && Contains(scimAlwaysReturned, strings.ToLower(path[0])) {
+			continue
+		}
+		excludeScimPath(resource, path)
+	}
+	return resource
+}
+
+// scimAttributePath splits an attribute such as "name.givenName" or
+// "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:employeeNumber" into resource keys
+func scimAttributePath(resource map[string]interface{}, attribute string) []string {
+	var path []string
+	if i := strings.LastIndex(attribute, ":"); i >= 0 {
+		// extension attributes live in a container keyed by their schema URN
+		if key, ok := scimKey(resource, attribute[:i]); ok {
+			path = append(path, key)
+		}
+		attribute = attribute[i+1:]
+	}
+	return append(path, strings.Split(attribute, ".")...)
+}
+
+// scimKey finds the key of a resource attribute, attribute names are case-insensitive
+func scimKey(resource map[string]interface{}, name string) (string, bool) {
+	if _, ok := resource[name]; ok {
+		return name, true
+	}
+	for key := range resource {
+		if strings.EqualFold(key, name) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+func includeScimPath(dst map[string]interface{}, src map[string]interface{}, path []string) {
+	key, ok := scimKey(src, path[0])
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		dst[key] = src[key]
+		return
+	}
+
+	switch value := src[key].(type) {
+	case map[string]interface{}:
+		child, _ := dst[key].(map[string]interface{})
+		if child == nil {
+			child = make(map[string]interface{})
+		}
+		includeScimPath(child, value, path[1:])
+		dst[key] = child
+	case []interface{}:
+		// sub-attributes of multi-valued attributes apply to every value, values that are not objects have none
+		var objects []map[string]interface{}
+		for _, elem := range value {
+			if elemMap, ok := elem.(map[string]interface{}); ok {
+				objects = append(objects, elemMap)
+			}
+		}
+		children, _ := dst[key].([]interface{})
+		if children == nil {
+			children = make([]interface{}, len(objects))
+		}
+		for i, elemMap := range objects {
+			child, _ := children[i].(map[string]interface{})
+			if child == nil {
+				child = make(map[string]interface{})
+			}
+			includeScimPath(child, elemMap, path[1:])
+			children[i] = child
+		}
+		dst[key] = children
+	}
+}
+
+func excludeScimPath(resource map[string]interface{}, path []string) {
+	key, ok := scimKey(resource, path[0])
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		delete(resource, key)
+		return
+	}
+
+	switch value := resource[key].(type) {
+	case map[string]interface{}:
+		excludeScimPath(value, path[1:])
+	case []interface{}:
+		for _, elem := range value {
+			if elemMap, ok := elem.(map[string]interface{}); ok {
+				excludeScimPath(elemMap, path[1:])
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/stretchr/testify/assert"
+)
+
+type scimTestEmail struct {
+	Value   string `json:"value"`
+	Primary bool   `json:"primary"`
+}
+
+type scimTestUser struct {
+	ID       string            `json:"id"`
+	Schemas  []string          `json:"schemas"`
+	UserName string            `json:"userName"`
+	Name     map[string]string `json:"name"`
+	Emails   []scimTestEmail   `json:"emails"`
+}
+
+func scimTestUsers() []scimTestUser {
+	return []scimTestUser{
+		{
+			ID: "1", Schemas: []string{"urn:ietf:params:scim:schemas:core:2.0:User"}, UserName: "jdoe",
+			Name:   map[string]string{"givenName": "John", "familyName": "Doe"},
+			Emails: []scimTestEmail{{Value: "john@example.org", Primary: true}},
+		},
+		{
+			ID: "2", Schemas: []string{"urn:ietf:params:scim:schemas:core:2.0:User"}, UserName: "asmith",
+			Name: map[string]string{"givenName": "Alice", "familyName": "Smith"},
+		},
+	}
+}
+
+func TestBuildScimListResponse(t *testing.T) {
+	params := ScimListParams{ListRequestParams: scim.ListRequestParams{StartIndex: 11, Count: 10}}
+	got, err := BuildScimListResponse(scimTestUsers(), 12, params)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ScimListResponseSchema}, got.Schemas)
+	assert.Equal(t, 12, got.TotalResults)
+	assert.Equal(t, 2, got.ItemsPerPage)
+	assert.Equal(t, 11, got.StartIndex)
+	assert.Equal(t, "jdoe", got.Resources[0]["userName"])
+
+	raw, err := json.Marshal(got)
+	assert.NoError(t, err)
+	assert.Contains(t, string(raw), `"Resources":[{`)
+}
+
+func TestBuildScimListResponse_Empty(t *testing.T) {
+	got, err := BuildScimListResponse(nil, 0, ScimListParams{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, got.ItemsPerPage)
+	assert.Equal(t, 1, got.StartIndex)
+	assert.NotNil(t, got.Resources)
+
+	_, err = BuildScimListResponse("rows", 0, ScimListParams{})
+	assert.Error(t, err)
+}
+
+func TestBuildScimListResponse_Attributes(t *testing.T) {
+	params := ScimListParams{
+		ListRequestParams: scim.ListRequestParams{StartIndex: 1, Count: 10},
+		Attributes:        []string{"urn:ietf:params:scim:schemas:core:2.0:User:username", "name.givenName", "emails.value"},
+	}
+	got, err := BuildScimListResponse(scimTestUsers(), 2, params)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"id":       "1",
+		"schemas":  []interface{}{"urn:ietf:params:scim:schemas:core:2.0:User"},
+		"userName": "jdoe",
+		"name":     map[string]interface{}{"givenName": "John"},
+		"emails":   []interface{}{map[string]interface{}{"value": "john@example.org"}},
+	}, got.Resources[0])
+}
+
+func TestBuildScimListResponse_ExcludedAttributes(t *testing.T) {
+	params := ScimListParams{
+		ListRequestParams:  scim.ListRequestParams{StartIndex: 1, Count: 1},
+		ExcludedAttributes: []string{"id", "emails.primary", "name"},
+	}
+	got, err := BuildScimListResponse(scimTestUsers(), 2, params)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, got.ItemsPerPage)
+	assert.Equal(t, map[string]interface{}{
+		"id":       "1",
+		"schemas":  []interface{}{"urn:ietf:params:scim:schemas:core:2.0:User"},
+		"userName": "jdoe",
+		"emails":   []interface{}{map[string]interface{}{"value": "john@example.org"}},
+	}, got.Resources[0])
+}
+
+func TestBuildScimListResponse_ZeroCount(t *testing.T) {
+	params, err := ParseScimListQuery(httptest.NewRequest(http.MethodGet, "/Users?count=0", nil), 100)
+	assert.NoError(t, err)
+	got, err := BuildScimListResponse(scimTestUsers(), 2, params)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, got.TotalResults)
+	assert.Equal(t, 0, got.ItemsPerPage)
+	assert.Empty(t, got.Resources)
+}
+
+func TestBuildScimListResponse_MixedMultiValued(t *testing.T) {
+	rows := []map[string]interface{}{{
+		"id":     "1",
+		"emails": []interface{}{"legacy@example.org", map[string]interface{}{"value": "john@example.org", "type": "work"}},
+	}}
+	params := ScimListParams{Attributes: []string{"emails.value", "emails.type"}}
+	params.Count = 10
+	got, err := BuildScimListResponse(rows, 1, params)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"id":     "1",
+		"emails": []interface{}{map[string]interface{}{"value": "john@example.org", "type": "work"}},
+	}, got.Resources[0])
+}
@@ -0,0 +1,581 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elimity-com/scim"
+	scimErrors "github.com/elimity-com/scim/errors"
+	"github.com/elimity-com/scim/optional"
+	"github.com/scim2/filter-parser/v2"
+)
+
+// ScimResourceMapping describes how a SCIM resource type is stored in a SQL table
+type ScimResourceMapping struct {
+	Table    string
+	IDColumn string
+	// VersionColumn holds an integer version used as the resource ETag, optional
+	VersionColumn string
+	// CreatedColumn and LastModifiedColumn hold the resource meta dates, optional
+	CreatedColumn      string
+	LastModifiedColumn string
+	// Attributes maps the SCIM attributes to their columns and is used for filtering as well.
+	// Attributes mapped to a whole JSON column (path "$") are stored as JSON documents,
+	// other JSON paths can only be used in filters.
+	Attributes map[filter.AttributePath]MappingValues
+	// Dialect of the database, DialectMySQL also covers SQLite
+	Dialect SqlDialect
+	// NewID generates the identifier of created resources, defaults to random GUIDs
+	NewID func() string
+}
+
+// sqlExecutor is implemented by both *sql.DB and *sql.Tx
+type sqlExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// SqlResourceHandler is a scim.ResourceHandler storing its resources in a database/sql table
+type SqlResourceHandler struct {
+	db      *sql.DB
+	exec    sqlExecutor
+	mapping ScimResourceMapping
+	columns []scimColumn
+}
+
+// scimColumn is a column holding a (sub-)attribute of the resource
+type scimColumn struct {
+	attributeName string
+	subAttribute  string
+	column        string
+	dataType      string
+	json          bool
+}
+
+// NewSqlResourceHandler creates a resource handler for the given mapping
+func NewSqlResourceHandler(db *sql.DB, mapping ScimResourceMapping) (*SqlResourceHandler, error) {
+	if db == nil {
+		return nil, errors.New("no database supplied")
+	}
+	if mapping.Table == "" || mapping.IDColumn == "" {
+		return nil, errors.New("resource mapping requires a table and an id column")
+	}
+	if mapping.Dialect == "" {
+		mapping.Dialect = DialectMySQL
+	}
+	if mapping.NewID == nil {
+		mapping.NewID = newGUID
+	}
+
+	var columns []scimColumn
+	for attrPath, v := range mapping.Attributes {
+		column := scimColumn{
+			attributeName: attrPath.AttributeName,
+			subAttribute:  attrPath.SubAttributeName(),
+			column:        v.MappingValue,
+			dataType:      v.DataType,
+		}
+		if jm, ok := parseJsonMapping(v.MappingValue); ok {
+			if jm.isArray() || len(jm.path) > 0 {
+				// only usable in filters
+				continue
+			}
+			column.column = jm.column
+			column.json = true
+		}
+		columns = append(columns, column)
+	}
+	sort.Slice(columns, func(i, j int) bool {
+		return columns[i].column < columns[j].column
+	})
+
+	return &SqlResourceHandler{db: db, exec: db, mapping: mapping, columns: columns}, nil
+}
+
+// WithTx returns a handler running every statement inside the given transaction
+func (h *SqlResourceHandler) WithTx(tx *sql.Tx) *SqlResourceHandler {
+	txHandler := *h
+	txHandler.exec = tx
+	return &txHandler
+}
+
+// Create stores the attributes as a new resource
+func (h *SqlResourceHandler) Create(r *http.Request, attributes scim.ResourceAttributes) (scim.Resource, error) {
+	values, err := h.columnValues(attributes)
+	if err != nil {
+		return scim.Resource{}, err
+	}
+	id := h.mapping.NewID()
+	now := time.Now().UTC()
+
+	columns := []string{h.mapping.IDColumn}
+	args := []interface{}{id}
+	if h.mapping.VersionColumn != "" {
+		columns, args = append(columns, h.mapping.VersionColumn), append(args, 1)
+	}
+	if h.mapping.CreatedColumn != "" {
+		columns, args = append(columns, h.mapping.CreatedColumn), append(args, now)
+	}
+	if h.mapping.LastModifiedColumn != "" {
+		columns, args = append(columns, h.mapping.LastModifiedColumn), append(args, now)
+	}
+	for i, column := range h.columns {
+		columns, args = append(columns, column.column), append(args, values[i])
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", h.mapping.Table, strings.Join(columns, ", "),
+		strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "))
+	if _, err = h.exec.ExecContext(r.Context(), h.rebind(query), args...); err != nil {
+		return scim.Resource{}, err
+	}
+
+	resource, _, err := h.load(r.Context(), h.exec, id)
+	return resource, err
+}
+
+// Get returns the resource with the given identifier
+func (h *SqlResourceHandler) Get(r *http.Request, id string) (scim.Resource, error) {
+	resource, _, err := h.load(r.Context(), h.exec, id)
+	return resource, err
+}
+
+// GetAll returns the page of resources matching the list request params
+func (h *SqlResourceHandler) GetAll(r *http.Request, params scim.ListRequestParams) (scim.Page, error) {
+	sqlQuery, err := ParseScimParamsWithDialect(params, h.mapping.Attributes, h.mapping.IDColumn, "asc", h.mapping.Dialect)
+	if err != nil {
+		return scim.Page{}, scimErrors.ScimErrorInvalidFilter
+	}
+
+	query, args, err := sqlQuery.SelectCount(SqlSelect{Table: h.mapping.Table})
+	if err != nil {
+		return scim.Page{}, err
+	}
+	page := scim.Page{Resources: []scim.Resource{}}
+	if err = h.exec.QueryRowContext(r.Context(), query, args...).Scan(&page.TotalResults); err != nil {
+		return scim.Page{}, err
+	}
+	if params.Count <= 0 || page.TotalResults == 0 {
+		return page, nil
+	}
+
+	query, args, err = sqlQuery.Select(SqlSelect{Table: h.mapping.Table, Columns: h.selectColumns()})
+	if err != nil {
+		return scim.Page{}, err
+	}
+	rows, err := h.exec.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		return scim.Page{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		resource, _, err := h.scanResource(rows)
+		if err != nil {
+			return scim.Page{}, err
+		}
+		page.Resources = append(page.Resources, resource)
+	}
+	return page, rows.Err()
+}
+
+// Replace replaces all attributes of the resource, attributes left out are cleared
+func (h *SqlResourceHandler) Replace(r *http.Request, id string, attributes scim.ResourceAttributes) (scim.Resource, error) {
+	var resource scim.Resource
+	err := h.inTx(r.Context(), func(exec sqlExecutor) error {
+		current, version, err := h.load(r.Context(), exec, id)
+		if err != nil {
+			return err
+		}
+		if err = checkIfMatch(r, current); err != nil {
+			return err
+		}
+		if err = h.update(r.Context(), exec, id, version, attributes); err != nil {
+			return err
+		}
+		resource, _, err = h.load(r.Context(), exec, id)
+		return err
+	})
+	return resource, err
+}
+
+// Patch applies the add, replace and remove operations to the resource
+func (h *SqlResourceHandler) Patch(r *http.Request, id string, operations []scim.PatchOperation) (scim.Resource, error) {
+	var resource scim.Resource
+	err := h.inTx(r.Context(), func(exec sqlExecutor) error {
+		current, version, err := h.load(r.Context(), exec, id)
+		if err != nil {
+			return err
+		}
+		if err = checkIfMatch(r, current); err != nil {
+			return err
+		}
+		attributes := current.Attributes
+		if current.ExternalID.Present() {
+			attributes["externalId"] = current.ExternalID.Value()
+		}
+		for _, operation := range operations {
+			if err = applyScimPatch(attributes, operation); err != nil {
+				return err
+			}
+		}
+		if err = h.update(r.Context(), exec, id, version, attributes); err != nil {
+			return err
+		}
+		resource, _, err = h.load(r.Context(), exec, id)
+		return err
+	})
+	return resource, err
+}
+
+// Delete removes the resource
+func (h *SqlResourceHandler) Delete(r *http.Request, id string) error {
+	return h.inTx(r.Context(), func(exec sqlExecutor) error {
+		current, _, err := h.load(r.Context(), exec, id)
+		if err != nil {
+			return err
+		}
+		if err = checkIfMatch(r, current); err != nil {
+			return err
+		}
+		query := fmt.Sprintf("DELETE FROM %s WHERE %s = ?", h.mapping.Table, h.mapping.IDColumn)
+		_, err = exec.ExecContext(r.Context(), h.rebind(query), id)
+		return err
+	})
+}
+
+func (h *SqlResourceHandler) update(ctx context.Context, exec sqlExecutor, id string, version int64, attributes scim.ResourceAttributes) error {
+	values, err := h.columnValues(attributes)
+	if err != nil {
+		return err
+	}
+
+	var assignments []string
+	var args []interface{}
+	if h.mapping.VersionColumn != "" {
+		assignments, args = append(assignments, h.mapping.VersionColumn+" = ?"), append(args, version+1)
+	}
+	if h.mapping.LastModifiedColumn != "" {
+		assignments, args = append(assignments, h.mapping.LastModifiedColumn+" = ?"), append(args, time.Now().UTC())
+	}
+	for i, column := range h.columns {
+		assignments, args = append(assignments, column.column+" = ?"), append(args, values[i])
+	}
+	if len(assignments) == 0 {
+		return nil
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s = ?", h.mapping.Table, strings.Join(assignments, ", "), h.mapping.IDColumn)
+	args = append(args, id)
+	if h.mapping.VersionColumn != "" {
+		// optimistic locking, the row must not have changed since it was loaded
+		query += fmt.Sprintf(" AND %s = ?", h.mapping.VersionColumn)
+		args = append(args, version)
+	}
+	result, err := exec.ExecContext(ctx, h.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	if h.mapping.VersionColumn != "" {
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			return preconditionFailed()
+		}
+	}
+	return nil
+}
+
+func (h *SqlResourceHandler) load(ctx context.Context, exec sqlExecutor, id string) (scim.Resource, int64, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?", strings.Join(h.selectColumns(), ", "), h.mapping.Table, h.mapping.IDColumn)
+	rows, err := exec.QueryContext(ctx, h.rebind(query), id)
+	if err != nil {
+		return scim.Resource{}, 0, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return scim.Resource{}, 0, err
+		}
+		return scim.Resource{}, 0, scimErrors.ScimErrorResourceNotFound(id)
+	}
+	return h.scanResource(rows)
+}
+
+func (h *SqlResourceHandler) selectColumns() []string {
+	columns := []string{h.mapping.IDColumn}
+	for _, column := range []string{h.mapping.VersionColumn, h.mapping.CreatedColumn, h.mapping.LastModifiedColumn} {
+		if column != "" {
+			columns = append(columns, column)
+		}
+	}
+	for _, column := range h.columns {
+		columns = append(columns, column.column)
+	}
+	return columns
+}
+
+func (h *SqlResourceHandler) scanResource(rows *sql.Rows) (scim.Resource, int64, error) {
+	values := make([]interface{}, len(h.selectColumns()))
+	pointers := make([]interface{}, len(values))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	if err := rows.Scan(pointers...); err != nil {
+		return scim.Resource{}, 0, err
+	}
+
+	resource := scim.Resource{ID: fmt.Sprint(sqlString(values[0])), Attributes: scim.ResourceAttributes{}}
+	values = values[1:]
+	var version int64
+	if h.mapping.VersionColumn != "" {
+		parsed, err := strconv.ParseInt(fmt.Sprint(sqlString(values[0])), 10, 64)
+		if err != nil {
+			return scim.Resource{}, 0, fmt.Errorf("invalid resource version: %v", err)
+		}
+		version = parsed
+		resource.Meta.Version = fmt.Sprintf("W/\"%d\"", version)
+		values = values[1:]
+	}
+	if h.mapping.CreatedColumn != "" {
+		resource.Meta.Created = sqlTime(values[0])
+		values = values[1:]
+	}
+	if h.mapping.LastModifiedColumn != "" {
+		resource.Meta.LastModified = sqlTime(values[0])
+		values = values[1:]
+	}
+
+	for i, column := range h.columns {
+		value, err := column.fromSql(values[i])
+		if err != nil {
+			return scim.Resource{}, 0, err
+		}
+		if value == nil {
+			continue
+		}
+		if strings.EqualFold(column.attributeName, "externalId") && column.subAttribute == "" {
+			resource.ExternalID = optional.NewString(fmt.Sprint(value))
+			continue
+		}
+		if column.subAttribute == "" {
+			resource.Attributes[column.attributeName] = value
+			continue
+		}
+		parent, _ := resource.Attributes[column.attributeName].(map[string]interface{})
+		if parent == nil {
+			parent = map[string]interface{}{}
+			resource.Attributes[column.attributeName] = parent
+		}
+		parent[column.subAttribute] = value
+	}
+	return resource, version, nil
+}
+
+// columnValues returns the value of every mapped column, in the order of h.columns
+func (h *SqlResourceHandler) columnValues(attributes scim.ResourceAttributes) ([]interface{}, error) {
+	values := make([]interface{}, len(h.columns))
+	for i, column := range h.columns {
+		value := lookupAttribute(attributes, column.attributeName)
+		if column.subAttribute != "" {
+			parent, _ := value.(map[string]interface{})
+			value = lookupAttribute(parent, column.subAttribute)
+		}
+		if value != nil && column.json {
+			raw, err := json.Marshal(value)
+			if err != nil {
+				return nil, scimErrors.ScimErrorBadRequest(fmt.Sprintf("invalid value for %s: %v", column.attributeName, err))
+			}
+			value = string(raw)
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func (h *SqlResourceHandler) inTx(ctx context.Context, fn func(exec sqlExecutor) error) error {
+	if h.exec != sqlExecutor(h.db) {
+		// already bound to a transaction
+		return fn(h.exec)
+	}
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (h *SqlResourceHandler) rebind(query string) string {
+	return h.mapping.Dialect.Rebind(query)
+}
+
+// fromSql converts a scanned column value into its attribute value
+func (c scimColumn) fromSql(value interface{}) (interface{}, error) {
+	value = sqlString(value)
+	if value == nil {
+		return nil, nil
+	}
+	if c.json {
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(fmt.Sprint(value)), &decoded); err != nil {
+			return nil, fmt.Errorf("invalid json in column %s: %v", c.column, err)
+		}
+		return decoded, nil
+	}
+
+	switch c.dataType {
+	case "int", "int64":
+		switch v := value.(type) {
+		case int64:
+			return int(v), nil
+		case string:
+			return strconv.Atoi(v)
+		}
+	case "bool":
+		switch v := value.(type) {
+		case int64:
+			return v != 0, nil
+		case string:
+			return strconv.ParseBool(v)
+		}
+	}
+	if t, ok := value.(time.Time); ok {
+		return t.Format(time.RFC3339), nil
+	}
+	return value, nil
+}
+
+// checkIfMatch verifies the If-Match header against the current version of the resource
+func checkIfMatch(r *http.Request, current scim.Resource) error {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" || ifMatch == "*" || current.Meta.Version == "" {
+		return nil
+	}
+	for _, etag := range strings.Split(ifMatch, ",") {
+		if strings.TrimSpace(etag) == current.Meta.Version {
+			return nil
+		}
+	}
+	return preconditionFailed()
+}
+
+func preconditionFailed() error {
+	return scimErrors.ScimError{
+		Detail: "the resource has been modified since the version supplied",
+		Status: http.StatusPreconditionFailed,
+	}
+}
+
+// applyScimPatch applies a single PATCH operation to the attributes
+func applyScimPatch(attributes map[string]interface{}, operation scim.PatchOperation) error {
+	op := strings.ToLower(operation.Op)
+	if operation.Path == nil {
+		values, ok := operation.Value.(map[string]interface{})
+		if !ok || op == scim.PatchOperationRemove {
+			return scimErrors.ScimErrorInvalidValue
+		}
+		for name, value := range values {
+			patchAttribute(attributes, name, value, op)
+		}
+		return nil
+	}
+	if operation.Path.ValueExpression != nil {
+		return scimErrors.ScimErrorBadRequest(fmt.Sprintf("value filters are not supported in path %q", operation.Path.String()))
+	}
+
+	name := operation.Path.AttributePath.AttributeName
+	subAttribute := operation.Path.AttributePath.SubAttributeName()
+	if subAttribute == "" {
+		patchAttribute(attributes, name, operation.Value, op)
+		return nil
+	}
+	key, ok := scimKey(attributes, name)
+	if !ok {
+		key = name
+	}
+	parent, _ := attributes[key].(map[string]interface{})
+	if parent == nil {
+		if op == scim.PatchOperationRemove {
+			return nil
+		}
+		parent = map[string]interface{}{}
+	}
+	patchAttribute(parent, subAttribute, operation.Value, op)
+	attributes[key] = parent
+	return nil
+}
+
+func patchAttribute(attributes map[string]interface{}, name string, value interface{}, op string) {
+	key, ok := scimKey(attributes, name)
+	if !ok {
+		key = name
+	}
+	if op == scim.PatchOperationRemove {
+		delete(attributes, key)
+		return
+	}
+	if op == scim.PatchOperationAdd {
+		// add appends to multi-valued attributes and merges complex ones
+		switch current := attributes[key].(type) {
+		case []interface{}:
+			if values, ok := value.([]interface{}); ok {
+				attributes[key] = append(current, values...)
+				return
+			}
+		case map[string]interface{}:
+			if values, ok := value.(map[string]interface{}); ok {
+				for subName, subValue := range values {
+					patchAttribute(current, subName, subValue, op)
+				}
+				return
+			}
+		}
+	}
+	attributes[key] = value
+}
+
+func lookupAttribute(attributes map[string]interface{}, name string) interface{} {
+	if key, ok := scimKey(attributes, name); ok {
+		return attributes[key]
+	}
+	return nil
+}
+
+func sqlString(value interface{}) interface{} {
+	if b, ok := value.([]byte); ok {
+		return string(b)
+	}
+	return value
+}
+
+func sqlTime(value interface{}) *time.Time {
+	switch v := sqlString(value).(type) {
+	case time.Time:
+		return &v
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05"} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return &t
+			}
+		}
+	}
+	return nil
+}
+
+func newGUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
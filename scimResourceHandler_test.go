@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	scimErrors "github.com/elimity-com/scim/errors"
+	"github.com/elimity-com/scim/schema"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/scim2/filter-parser/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+var userResourceMapping = ScimResourceMapping{
+	Table:              "users",
+	IDColumn:           "id",
+	VersionColumn:      "version",
+	CreatedColumn:      "created",
+	LastModifiedColumn: "last_modified",
+	Attributes: map[filter.AttributePath]MappingValues{
+		filter.AttributePath{AttributeName: "userName"}:                                   {"user_name", "string", true},
+		filter.AttributePath{AttributeName: "name", SubAttribute: StringPtr("givenName")}: {"given_name", "string", true},
+		filter.AttributePath{AttributeName: "active"}:                                     {"active", "bool", false},
+		filter.AttributePath{AttributeName: "emails"}:                                     NewJsonMappingValues("emails", "$", "string", false),
+	},
+}
+
+func newTestUserHandler(t *testing.T) (*sql.DB, *SqlResourceHandler) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection would get its own in-memory database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	_, err = db.Exec(`CREATE TABLE users (
+		id TEXT PRIMARY KEY, version INTEGER NOT NULL, created DATETIME, last_modified DATETIME,
+		user_name TEXT NOT NULL UNIQUE, given_name TEXT, active INTEGER, emails TEXT)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := NewSqlResourceHandler(db, userResourceMapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, handler
+}
+
+func TestSqlResourceHandler_CreateGet(t *testing.T) {
+	_, handler := newTestUserHandler(t)
+	r := httptest.NewRequest(http.MethodPost, "/Users", nil)
+
+	created, err := handler.Create(r, scim.ResourceAttributes{
+		"userName": "jdoe",
+		"name":     map[string]interface{}{"givenName": "John"},
+		"active":   true,
+		"emails":   []interface{}{map[string]interface{}{"value": "john@example.org"}},
+	})
+	assert.NoError(t, err)
+	assert.True(t, IsValidGUID(created.ID))
+	assert.Equal(t, `W/"1"`, created.Meta.Version)
+	assert.NotNil(t, created.Meta.Created)
+
+	got, err := handler.Get(r, created.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, scim.ResourceAttributes{
+		"userName": "jdoe",
+		"name":     map[string]interface{}{"givenName": "John"},
+		"active":   true,
+		"emails":   []interface{}{map[string]interface{}{"value": "john@example.org"}},
+	}, got.Attributes)
+
+	_, err = handler.Get(r, "unknown")
+	assert.Equal(t, scimErrors.ScimErrorResourceNotFound("unknown"), err)
+}
+
+func TestSqlResourceHandler_GetAll(t *testing.T) {
+	_, handler := newTestUserHandler(t)
+	r := httptest.NewRequest(http.MethodGet, "/Users", nil)
+	for _, userName := range []string{"adam", "bert", "bill", "carl"} {
+		_, err := handler.Create(r, scim.ResourceAttributes{"userName": userName})
+		assert.NoError(t, err)
+	}
+
+	expression, err := filter.ParseFilter([]byte(`userName sw "b" or userName eq "carl"`))
+	assert.NoError(t, err)
+	page, err := handler.GetAll(r, scim.ListRequestParams{Filter: expression, StartIndex: 2, Count: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, page.TotalResults)
+	assert.Len(t, page.Resources, 1)
+
+	page, err = handler.GetAll(r, scim.ListRequestParams{StartIndex: 1, Count: 0})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, page.TotalResults)
+	assert.Empty(t, page.Resources)
+
+	expression, err = filter.ParseFilter([]byte(`title eq "CEO"`))
+	assert.NoError(t, err)
+	_, err = handler.GetAll(r, scim.ListRequestParams{Filter: expression, StartIndex: 1, Count: 10})
+	assert.Equal(t, scimErrors.ScimErrorInvalidFilter, err)
+}
+
+func TestSqlResourceHandler_ReplaceWithETag(t *testing.T) {
+	_, handler := newTestUserHandler(t)
+	created, err := handler.Create(httptest.NewRequest(http.MethodPost, "/Users", nil),
+		scim.ResourceAttributes{"userName": "jdoe", "active": true})
+	assert.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodPut, "/Users/"+created.ID, nil)
+	r.Header.Set("If-Match", created.Meta.Version)
+	replaced, err := handler.Replace(r, created.ID, scim.ResourceAttributes{"userName": "john"})
+	assert.NoError(t, err)
+	assert.Equal(t, `W/"2"`, replaced.Meta.Version)
+	assert.Equal(t, scim.ResourceAttributes{"userName": "john"}, replaced.Attributes)
+
+	// the version supplied is outdated now
+	_, err = handler.Replace(r, created.ID, scim.ResourceAttributes{"userName": "jdoe"})
+	assert.Equal(t, http.StatusPreconditionFailed, err.(scimErrors.ScimError).Status)
+}
+
+func TestSqlResourceHandler_Patch(t *testing.T) {
+	_, handler := newTestUserHandler(t)
+	r := httptest.NewRequest(http.MethodPatch, "/Users", nil)
+	created, err := handler.Create(r, scim.ResourceAttributes{
+		"userName": "jdoe",
+		"name":     map[string]interface{}{"givenName": "John"},
+		"emails":   []interface{}{map[string]interface{}{"value": "john@example.org"}},
+	})
+	assert.NoError(t, err)
+
+	emailsPath, _ := filter.ParsePath([]byte("emails"))
+	givenNamePath, _ := filter.ParsePath([]byte("name.givenName"))
+	patched, err := handler.Patch(r, created.ID, []scim.PatchOperation{
+		{Op: scim.PatchOperationAdd, Path: &emailsPath, Value: []interface{}{map[string]interface{}{"value": "jdoe@example.org"}}},
+		{Op: scim.PatchOperationRemove, Path: &givenNamePath},
+		{Op: scim.PatchOperationReplace, Value: map[string]interface{}{"active": false}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, scim.ResourceAttributes{
+		"userName": "jdoe",
+		"active":   false,
+		"emails": []interface{}{
+			map[string]interface{}{"value": "john@example.org"},
+			map[string]interface{}{"value": "jdoe@example.org"},
+		},
+	}, patched.Attributes)
+
+	filterPath, _ := filter.ParsePath([]byte(`emails[value eq "john@example.org"]`))
+	_, err = handler.Patch(r, created.ID, []scim.PatchOperation{{Op: scim.PatchOperationRemove, Path: &filterPath}})
+	assert.Error(t, err)
+}
+
+func TestSqlResourceHandler_Delete(t *testing.T) {
+	_, handler := newTestUserHandler(t)
+	created, err := handler.Create(httptest.NewRequest(http.MethodPost, "/Users", nil), scim.ResourceAttributes{"userName": "jdoe"})
+	assert.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodDelete, "/Users/"+created.ID, nil)
+	r.Header.Set("If-Match", `W/"5"`)
+	assert.Error(t, handler.Delete(r, created.ID))
+
+	r.Header.Set("If-Match", created.Meta.Version)
+	assert.NoError(t, handler.Delete(r, created.ID))
+	assert.Equal(t, scimErrors.ScimErrorResourceNotFound(created.ID), handler.Delete(r, created.ID))
+}
+
+func TestSqlResourceHandler_Server(t *testing.T) {
+	_, handler := newTestUserHandler(t)
+	server := scim.Server{ResourceTypes: []scim.ResourceType{{
+		Name:     "User",
+		Endpoint: "/Users",
+		Schema:   schema.CoreUserSchema(),
+		Handler:  handler,
+	}}}
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/Users", bytes.NewBufferString(
+		`{"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"], "userName": "jdoe", "name": {"givenName": "John"}}`)))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, `W/"1"`, w.Header().Get("Etag"))
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, `/Users?filter=name.givenName+eq+%22John%22`, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, float64(1), response["totalResults"])
+}
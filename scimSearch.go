@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/elimity-com/scim"
+	scimErrors "github.com/elimity-com/scim/errors"
+	"github.com/scim2/filter-parser/v2"
+)
+
+const (
+	// ScimSearchRequestSchema const
+	ScimSearchRequestSchema = "urn:ietf:params:scim:api:messages:2.0:SearchRequest"
+	// ScimSortAscending const
+	ScimSortAscending = "ascending"
+	// ScimSortDescending const
+	ScimSortDescending = "descending"
+)
+
+// ScimSearchRequest is the JSON body of a SCIM POST /.search request (RFC 7644 §3.4.3)
+type ScimSearchRequest struct {
+	Schemas            []string `json:"schemas"`
+	Attributes         []string `json:"attributes,omitempty"`
+	ExcludedAttributes []string `json:"excludedAttributes,omitempty"`
+	Filter             string   `json:"filter,omitempty"`
+	SortBy             string   `json:"sortBy,omitempty"`
+	SortOrder          string   `json:"sortOrder,omitempty"`
+	StartIndex         *int     `json:"startIndex,omitempty"`
+	Count              *int     `json:"count,omitempty"`
+}
+
+// ScimListParams extends scim.ListRequestParams with the sorting and attribute
+// selection parameters shared by GET and POST search requests
+type ScimListParams struct {
+	scim.ListRequestParams
+	SortBy             string
+	SortOrder          string
+	Attributes         []string
+	ExcludedAttributes []string
+}
+
+// ParseScimSearchRequest decodes and validates a POST /.search request body into list params.
+// maxCount caps the requested page size the same way the SCIM server does for GET requests.
+func ParseScimSearchRequest(r *http.Request, maxCount int) (ScimListParams, error) {
+	body, err := GetRequestBody(r)
+	if err != nil {
+		return ScimListParams{}, scimErrors.ScimErrorInvalidSyntax
+	}
+	return ParseScimSearchBody(body, maxCount)
+}
+
+// ParseScimSearchBody decodes and validates a raw SearchRequest body into list params
+func ParseScimSearchBody(body []byte, maxCount int) (ScimListParams, error) {
+	var request ScimSearchRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return ScimListParams{}, scimErrors.ScimErrorInvalidSyntax
+	}
+	if !Contains(request.Schemas, ScimSearchRequestSchema) || len(request.Schemas) != 1 {
+		return ScimListParams{}, scimErrors.ScimErrorBadRequest(fmt.Sprintf("schemas must be [%q]", ScimSearchRequestSchema))
+	}
+
+	count, startIndex := maxCount, 1
+	if request.Count != nil {
+		count = *request.Count
+	}
+	if request.StartIndex != nil {
+		startIndex = *request.StartIndex
+	}
+	return newScimListParams(request.Filter, request.SortBy, request.SortOrder, startIndex, count, maxCount,
+		request.Attributes, request.ExcludedAttributes)
+}
+
+// ParseScimListQuery parses the query string of a GET list request into list params,
+// so GET and POST search requests are handled the same way
+func ParseScimListQuery(r *http.Request, maxCount int) (ScimListParams, error) {
+	query := r.URL.Query()
+	var invalidParams []string
+
+	count, startIndex := maxCount, 1
+	if v := query.Get("count"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil {
+			invalidParams = append(invalidParams, "count")
+		}
+		count = parsed
+	}
+	if v := query.Get("startIndex"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil {
+			invalidParams = append(invalidParams, "startIndex")
+		}
+		startIndex = parsed
+	}
+	if len(invalidParams) > 0 {
+		return ScimListParams{}, scimErrors.ScimErrorBadParams(invalidParams)
+	}
+
+	return newScimListParams(query.Get("filter"), query.Get("sortBy"), query.Get("sortOrder"), startIndex, count, maxCount,
+		splitAttributes(query.Get("attributes")), splitAttributes(query.Get("excludedAttributes")))
+}
+
+// SqlQuery translates the list params into a SqlQuery, resolving sortBy through the field mappings.
+// defaultOrderField is used when no sortBy has been requested.
+func (p ScimListParams) SqlQuery(fieldMappings map[filter.AttributePath]MappingValues, defaultOrderField string) (*SqlQuery, error) {
+	return p.SqlQueryWithDialect(fieldMappings, defaultOrderField, DialectMySQL)
+}
+
+// SqlQueryWithDialect works as SqlQuery, rendering the query for the given dialect
+func (p ScimListParams) SqlQueryWithDialect(fieldMappings map[filter.AttributePath]MappingValues, defaultOrderField string, dialect SqlDialect) (*SqlQuery, error) {
+	orderField := defaultOrderField
+	if p.SortBy != "" {
+		attrPath, err := filter.ParseAttrPath([]byte(p.SortBy))
+		if err != nil {
+			return nil, scimErrors.ScimErrorInvalidPath
+		}
+		mapping, ok := lookupMapping(fieldMappings, attrPath)
+		if !ok || !mapping.IsSortable {
+			return nil, scimErrors.ScimErrorBadRequest(fmt.Sprintf("attribute %q is not sortable", p.SortBy))
+		}
+		orderField = mapping.MappingValue
+		if jm, ok := parseJsonMapping(mapping.MappingValue); ok {
+			if jm.isArray() {
+				return nil, scimErrors.ScimErrorBadRequest(fmt.Sprintf("attribute %q is not sortable", p.SortBy))
+			}
+			orderField = jm.scalarExpression(dialect, mapping.DataType)
+		}
+	}
+
+	orderDirection := "asc"
+	if p.SortOrder == ScimSortDescending {
+		orderDirection = "desc"
+	}
+	return ParseScimParamsWithDialect(p.ListRequestParams, fieldMappings, orderField, orderDirection, dialect)
+}
+
+func newScimListParams(rawFilter, sortBy, sortOrder string, startIndex, count, maxCount int, attributes, excludedAttributes []string) (ScimListParams, error) {
+	params := ScimListParams{
+		SortBy:             sortBy,
+		Attributes:         attributes,
+		ExcludedAttributes: excludedAttributes,
+	}
+
+	if count > maxCount {
+		count = maxCount
+	}
+	if count < 0 {
+		// A negative value shall be interpreted as 0.
+		count = 0
+	}
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	params.Count = count
+	params.StartIndex = startIndex
+
+	switch strings.ToLower(sortOrder) {
+	case "", ScimSortAscending:
+		params.SortOrder = ScimSortAscending
+	case ScimSortDescending:
+		params.SortOrder = ScimSortDescending
+	default:
+		return ScimListParams{}, scimErrors.ScimErrorBadParams([]string{"sortOrder"})
+	}
+
+	if rawFilter != "" {
+		expression, err := filter.ParseFilter([]byte(rawFilter))
+		if err != nil {
+			return ScimListParams{}, scimErrors.ScimErrorInvalidFilter
+		}
+		params.Filter = expression
+	}
+	return params, nil
+}
+
+func splitAttributes(value string) []string {
+	if value == "" {
+		return nil
+	}
+	var attributes []string
+	for _, attribute := range strings.Split(value, ",") {
+		if attribute = strings.TrimSpace(attribute); attribute != "" {
+			attributes = append(attributes, attribute)
+		}
+	}
+	return attributes
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	scimErrors "github.com/elimity-com/scim/errors"
+	"github.com/scim2/filter-parser/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+var searchMappings = map[filter.AttributePath]MappingValues{
+	filter.AttributePath{AttributeName: "userName"}: {"u.user_name", "string", true},
+	filter.AttributePath{AttributeName: "title"}:    {"u.title", "string", false},
+}
+
+func TestParseScimSearchBody(t *testing.T) {
+	body := []byte(`{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:SearchRequest"],
+		"attributes": ["displayName", "userName"],
+		"filter": "userName sw \"J\"",
+		"sortBy": "userName",
+		"sortOrder": "descending",
+		"startIndex": 3,
+		"count": 500
+	}`)
+	got, err := ParseScimSearchBody(body, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, got.Count)
+	assert.Equal(t, 3, got.StartIndex)
+	assert.Equal(t, "userName", got.SortBy)
+	assert.Equal(t, ScimSortDescending, got.SortOrder)
+	assert.Equal(t, []string{"displayName", "userName"}, got.Attributes)
+	assert.NotNil(t, got.Filter)
+
+	sqlQuery, err := got.SqlQuery(searchMappings, "u.id")
+	assert.NoError(t, err)
+	assert.Equal(t, "order by u.user_name desc", sqlQuery.OrderBy)
+	assert.Equal(t, []interface{}{"J%"}, sqlQuery.GetParameterList())
+}
+
+func TestParseScimSearchBody_Invalid(t *testing.T) {
+	_, err := ParseScimSearchBody([]byte(`{"filter": "userName pr"}`), 100)
+	assert.Error(t, err)
+
+	_, err = ParseScimSearchBody([]byte(`{"schemas": [`), 100)
+	assert.Equal(t, scimErrors.ScimErrorInvalidSyntax, err)
+
+	_, err = ParseScimSearchBody([]byte(`{"schemas": ["urn:ietf:params:scim:api:messages:2.0:SearchRequest"], "filter": "userName xx"}`), 100)
+	assert.Equal(t, scimErrors.ScimErrorInvalidFilter, err)
+
+	_, err = ParseScimSearchBody([]byte(`{"schemas": ["urn:ietf:params:scim:api:messages:2.0:SearchRequest"], "sortOrder": "up"}`), 100)
+	assert.Error(t, err)
+}
+
+func TestParseScimSearchRequest_SameAsGet(t *testing.T) {
+	post := httptest.NewRequest("POST", "/Users/.search", bytes.NewBufferString(
+		`{"schemas": ["urn:ietf:params:scim:api:messages:2.0:SearchRequest"], "filter": "title eq \"CEO\"", "count": 10, "excludedAttributes": ["emails"]}`))
+	get := httptest.NewRequest("GET", `/Users?filter=title+eq+%22CEO%22&count=10&excludedAttributes=emails`, nil)
+
+	fromPost, err := ParseScimSearchRequest(post, 100)
+	assert.NoError(t, err)
+	fromGet, err := ParseScimListQuery(get, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, fromGet, fromPost)
+
+	_, err = ParseScimListQuery(httptest.NewRequest("GET", "/Users?count=ten", nil), 100)
+	assert.Error(t, err)
+}
+
+func TestScimListParams_SqlQuery_NotSortable(t *testing.T) {
+	params, err := ParseScimSearchBody([]byte(`{"schemas": ["urn:ietf:params:scim:api:messages:2.0:SearchRequest"], "sortBy": "title"}`), 100)
+	assert.NoError(t, err)
+	_, err = params.SqlQuery(searchMappings, "u.id")
+	assert.Error(t, err)
+}
+
+func TestScimListParams_SqlQuery_Paging(t *testing.T) {
+	tests := []struct {
+		startIndex int
+		mysql      string
+		postgres   string
+	}{
+		{startIndex: 0, mysql: "limit 0, 10", postgres: "limit 10 offset 0"},
+		{startIndex: 1, mysql: "limit 0, 10", postgres: "limit 10 offset 0"},
+		{startIndex: 2, mysql: "limit 10, 10", postgres: "limit 10 offset 10"},
+	}
+	for _, test := range tests {
+		params := ScimListParams{}
+		params.StartIndex, params.Count = test.startIndex, 10
+
+		query, err := params.SqlQuery(searchMappings, "u.id")
+		if assert.NoError(t, err) {
+			assert.Equal(t, test.mysql, query.Limit, "startIndex %d", test.startIndex)
+		}
+		query, err = params.SqlQueryWithDialect(searchMappings, "u.id", DialectPostgres)
+		if assert.NoError(t, err) {
+			assert.Equal(t, test.postgres, query.Limit, "startIndex %d", test.startIndex)
+		}
+	}
+}
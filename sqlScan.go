@@ -0,0 +1,299 @@
+package utils
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/scim2/filter-parser/v2"
+)
+
+// ScanOption customizes ScanRowsWithOption and ScanRowWithOption
+type ScanOption struct {
+	// CopyOption holds the copier rules the column values are set into fields with
+	CopyOption
+	// Mappings matches columns to the fields of the SCIM attributes mapped to them, e.g. the column
+	// given_name to the field Name.GivenName for name.givenName
+	Mappings map[filter.AttributePath]MappingValues
+}
+
+// ScanRows scans every row into dest, a pointer to a []T or []*T, and closes rows.
+// Columns match struct fields by `db` tag, then by name ignoring case and underscores.
+// Embedded structs are supported, NULL sets pointers to nil and other fields to their zero value,
+// and values are converted with SemanticConversions.
+func ScanRows(rows *sql.Rows, dest interface{}) error {
+	return ScanRowsWithOption(rows, dest, ScanOption{CopyOption: CopyOption{SemanticConversions: true}})
+}
+
+// ScanRowsWithOption scans every row into dest like ScanRows, customized by opt
+func ScanRowsWithOption(rows *sql.Rows, dest interface{}, opt ScanOption) error {
+	defer rows.Close()
+
+	slice := reflect.ValueOf(dest)
+	if slice.Kind() != reflect.Ptr || slice.IsNil() || slice.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("scan destination must be a pointer to a slice, got %T", dest)
+	}
+	slice = slice.Elem()
+	elemType := slice.Type().Elem()
+
+	scanner, err := newRowScanner(rows, elemType, opt)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		elem := reflect.New(elemType).Elem()
+		if err = scanner.scan(rows, elem); err != nil {
+			return err
+		}
+		slice.Set(reflect.Append(slice, elem))
+	}
+	return rows.Err()
+}
+
+// ScanRow scans the first row into dest, a pointer to a struct or a single column value, and closes rows.
+// It returns sql.ErrNoRows when there is no row. Columns match fields like with ScanRows.
+func ScanRow(rows *sql.Rows, dest interface{}) error {
+	return ScanRowWithOption(rows, dest, ScanOption{CopyOption: CopyOption{SemanticConversions: true}})
+}
+
+// ScanRowWithOption scans the first row into dest like ScanRow, customized by opt
+func ScanRowWithOption(rows *sql.Rows, dest interface{}, opt ScanOption) error {
+	defer rows.Close()
+
+	target := reflect.ValueOf(dest)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return fmt.Errorf("scan destination must be a non-nil pointer, got %T", dest)
+	}
+	scanner, err := newRowScanner(rows, target.Type().Elem(), opt)
+	if err != nil {
+		return err
+	}
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	if err = scanner.scan(rows, target.Elem()); err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// rowScanner scans the columns of rows into values of one type
+type rowScanner struct {
+	columns []string
+	// fields holds the index of the field of every column, nil for columns scanned into a scalar
+	// or discarded
+	fields [][]int
+	// discard marks columns without a field
+	discard []bool
+	// direct marks columns scanned straight into fields implementing sql.Scanner
+	direct []bool
+	// pointer is set when the values are pointers to the struct type
+	pointer bool
+	opt     CopyOption
+}
+
+func newRowScanner(rows *sql.Rows, elemType reflect.Type, opt ScanOption) (*rowScanner, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	scanner := &rowScanner{
+		columns: columns,
+		fields:  make([][]int, len(columns)),
+		discard: make([]bool, len(columns)),
+		direct:  make([]bool, len(columns)),
+		opt:     opt.CopyOption,
+	}
+
+	structType := elemType
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+		scanner.pointer = true
+	}
+	if structType.Kind() != reflect.Struct || reflect.PtrTo(structType).Implements(scannerType) {
+		if len(columns) != 1 {
+			return nil, fmt.Errorf("cannot scan %d columns into %s", len(columns), elemType)
+		}
+		scanner.pointer = false
+		return scanner, nil
+	}
+
+	mappedFields := mappedColumnFields(structType, opt.Mappings)
+	for i, column := range columns {
+		index, ok := mappedFields[strings.ToLower(column)]
+		if !ok {
+			index, ok = columnField(structType, column)
+		}
+		if !ok {
+			if opt.Strict {
+				return nil, fmt.Errorf("%s has no field for column %q", structType.Name(), column)
+			}
+			scanner.discard[i] = true
+			continue
+		}
+		scanner.fields[i] = index
+		scanner.direct[i] = reflect.PtrTo(fieldTypeByIndex(structType, index)).Implements(scannerType)
+	}
+	return scanner, nil
+}
+
+func (scanner *rowScanner) scan(rows *sql.Rows, elem reflect.Value) error {
+	target := elem
+	if scanner.pointer {
+		elem.Set(reflect.New(elem.Type().Elem()))
+		target = elem.Elem()
+	}
+
+	values := make([]interface{}, len(scanner.columns))
+	destinations := make([]interface{}, len(scanner.columns))
+	for i := range scanner.columns {
+		destinations[i] = &values[i]
+		if scanner.direct[i] {
+			destinations[i] = fieldByIndexAlloc(target, scanner.fields[i]).Addr().Interface()
+		}
+	}
+	if err := rows.Scan(destinations...); err != nil {
+		return err
+	}
+
+	for i, column := range scanner.columns {
+		if scanner.discard[i] || scanner.direct[i] {
+			continue
+		}
+		field := target
+		if scanner.fields[i] != nil {
+			field = fieldByIndexAlloc(target, scanner.fields[i])
+		}
+		if err := scanner.setColumn(field, values[i]); err != nil {
+			return fmt.Errorf("scanning column %q into %s: %w", column, field.Type(), err)
+		}
+	}
+	return nil
+}
+
+// setColumn sets the field to the value of a column by the copier rules
+func (scanner *rowScanner) setColumn(field reflect.Value, value interface{}) error {
+	if value == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+
+	fieldType := field.Type()
+	for fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	switch v := value.(type) {
+	case []byte:
+		// drivers return text as bytes, which the field may not hold as is
+		if fieldType.Kind() != reflect.Slice {
+			value = string(v)
+		} else {
+			value = append([]byte(nil), v...)
+		}
+	case int64:
+		// drivers without a boolean type return 0 and 1
+		if fieldType.Kind() == reflect.Bool {
+			if v != 0 && v != 1 {
+				return fmt.Errorf("cannot convert %d to bool", v)
+			}
+			value = v == 1
+		}
+	}
+
+	ok, err := set(field, reflect.ValueOf(value), scanner.opt)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return CopyWithOption(field.Addr().Interface(), value, scanner.opt)
+	}
+	return nil
+}
+
+// columnField finds the field of a column by `db` tag, then by name ignoring case and underscores
+func columnField(structType reflect.Type, column string) ([]int, bool) {
+	normalized := normalizeColumnName(column)
+	var byName []int
+	for _, field := range reflect.VisibleFields(structType) {
+		if !field.IsExported() || (field.Anonymous && field.Type.Kind() == reflect.Struct) {
+			continue
+		}
+		tag, _, _ := strings.Cut(field.Tag.Get("db"), ",")
+		if tag == "-" || parseCopierTag(field).Ignore {
+			continue
+		}
+		if strings.EqualFold(tag, column) {
+			return field.Index, true
+		}
+		if tag == "" && byName == nil && normalizeColumnName(parseCopierTag(field).CopyName(field.Name)) == normalized {
+			byName = field.Index
+		}
+	}
+	return byName, byName != nil
+}
+
+// mappedColumnFields indexes the fields of the SCIM attributes mapped to plain columns by lower case column
+func mappedColumnFields(structType reflect.Type, mappings map[filter.AttributePath]MappingValues) map[string][]int {
+	fields := map[string][]int{}
+	for attrPath, mapping := range mappings {
+		column := mapping.MappingValue
+		if jm, ok := parseJsonMapping(column); ok {
+			// only whole JSON columns map to a single field
+			if jm.isArray() || len(jm.path) > 0 {
+				continue
+			}
+			column = jm.column
+		}
+		if i := strings.LastIndex(column, "."); i >= 0 {
+			column = column[i+1:]
+		}
+
+		names := []string{attrPath.AttributeName}
+		if attrPath.SubAttribute != nil {
+			names = append(names, *attrPath.SubAttribute)
+		}
+		if index, ok := fieldPath(structType, names); ok {
+			fields[strings.ToLower(column)] = index
+		}
+	}
+	return fields
+}
+
+// fieldPath finds the index of the nested field named by names, ignoring case
+func fieldPath(structType reflect.Type, names []string) ([]int, bool) {
+	var index []int
+	for _, name := range names {
+		for structType.Kind() == reflect.Ptr {
+			structType = structType.Elem()
+		}
+		if structType.Kind() != reflect.Struct {
+			return nil, false
+		}
+		field, ok := structType.FieldByNameFunc(func(fieldName string) bool { return strings.EqualFold(fieldName, name) })
+		if !ok {
+			return nil, false
+		}
+		index = append(index, field.Index...)
+		structType = field.Type
+	}
+	return index, true
+}
+
+func fieldTypeByIndex(structType reflect.Type, index []int) reflect.Type {
+	fieldType := structType
+	for _, i := range index {
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		fieldType = fieldType.Field(i).Type
+	}
+	return fieldType
+}
+
+func normalizeColumnName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
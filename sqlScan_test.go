@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+type scanAudit struct {
+	Created time.Time `db:"created"`
+	Version int
+}
+
+type scanUser struct {
+	scanAudit
+	ID       string `db:"id"`
+	UserName string
+	Given    *string `db:"given_name"`
+	Active   bool
+	Emails   sql.NullString
+	Ignored  string `copier:"-"`
+}
+
+func newScanTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	_, err = db.Exec(`CREATE TABLE users (
+		id TEXT PRIMARY KEY, version INTEGER NOT NULL, created DATETIME, last_modified DATETIME,
+		user_name TEXT NOT NULL UNIQUE, given_name TEXT, active INTEGER, emails TEXT)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO users (id, version, created, user_name, given_name, active, emails) VALUES
+		('1', 1, '2024-01-02 03:04:05', 'jdoe', 'John', 1, '["jdoe@example.com"]'),
+		('2', 3, '2024-02-03 04:05:06', 'asmith', NULL, 0, NULL)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestScanRows(t *testing.T) {
+	db := newScanTestDB(t)
+
+	rows, err := db.Query(`SELECT * FROM users ORDER BY id`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var users []scanUser
+	if !assert.NoError(t, ScanRows(rows, &users)) || !assert.Len(t, users, 2) {
+		return
+	}
+
+	assert.Equal(t, "1", users[0].ID)
+	assert.Equal(t, "jdoe", users[0].UserName)
+	assert.Equal(t, "John", *users[0].Given)
+	assert.True(t, users[0].Active)
+	assert.Equal(t, sql.NullString{String: `["jdoe@example.com"]`, Valid: true}, users[0].Emails)
+	assert.Equal(t, 1, users[0].Version)
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), users[0].Created)
+
+	assert.Nil(t, users[1].Given)
+	assert.False(t, users[1].Active)
+	assert.False(t, users[1].Emails.Valid)
+	assert.Equal(t, 3, users[1].Version)
+}
+
+func TestScanRows_Pointers(t *testing.T) {
+	db := newScanTestDB(t)
+
+	rows, err := db.Query(`SELECT id, user_name FROM users ORDER BY id`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var users []*scanUser
+	if assert.NoError(t, ScanRows(rows, &users)) && assert.Len(t, users, 2) {
+		assert.Equal(t, "jdoe", users[0].UserName)
+		assert.Equal(t, "asmith", users[1].UserName)
+	}
+}
+
+func TestScanRows_Scalars(t *testing.T) {
+	db := newScanTestDB(t)
+
+	rows, err := db.Query(`SELECT version FROM users ORDER BY id`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var versions []int
+	if assert.NoError(t, ScanRows(rows, &versions)) {
+		assert.Equal(t, []int{1, 3}, versions)
+	}
+
+	rows, err = db.Query(`SELECT id, version FROM users`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.EqualError(t, ScanRows(rows, &versions), "cannot scan 2 columns into int")
+}
+
+func TestScanRows_Errors(t *testing.T) {
+	db := newScanTestDB(t)
+
+	rows, err := db.Query(`SELECT id FROM users`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var user scanUser
+	assert.EqualError(t, ScanRows(rows, &user), "scan destination must be a pointer to a slice, got *utils.scanUser")
+
+	rows, err = db.Query(`SELECT id, last_modified FROM users`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var users []scanUser
+	assert.NoError(t, ScanRows(rows, &users))
+
+	rows, err = db.Query(`SELECT id, last_modified FROM users`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	err = ScanRowsWithOption(rows, &users, ScanOption{CopyOption: CopyOption{Strict: true}})
+	assert.EqualError(t, err, `scanUser has no field for column "last_modified"`)
+
+	rows, err = db.Query(`SELECT user_name AS version FROM users`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	err = ScanRows(rows, &users)
+	assert.ErrorContains(t, err, `scanning column "version" into int`)
+}
+
+func TestScanRowsWithOption_Mappings(t *testing.T) {
+	db := newScanTestDB(t)
+
+	type name struct {
+		GivenName string
+	}
+	type user struct {
+		Login  string
+		Name   *name
+		Emails []byte
+	}
+	rows, err := db.Query(`SELECT u.user_name, u.given_name, u.emails FROM users u ORDER BY u.id`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var users []user
+	err = ScanRowsWithOption(rows, &users, ScanOption{
+		CopyOption: CopyOption{SemanticConversions: true},
+		Mappings:   userResourceMapping.Attributes,
+	})
+	if assert.NoError(t, err) && assert.Len(t, users, 2) {
+		assert.Equal(t, "John", users[0].Name.GivenName)
+		assert.Equal(t, `["jdoe@example.com"]`, string(users[0].Emails))
+		assert.Nil(t, users[1].Emails)
+	}
+}
+
+func TestScanRow(t *testing.T) {
+	db := newScanTestDB(t)
+
+	rows, err := db.Query(`SELECT * FROM users WHERE id = ?`, "2")
+	if !assert.NoError(t, err) {
+		return
+	}
+	var user scanUser
+	if assert.NoError(t, ScanRow(rows, &user)) {
+		assert.Equal(t, "asmith", user.UserName)
+	}
+
+	rows, err = db.Query(`SELECT COUNT(*) FROM users`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var count int64
+	if assert.NoError(t, ScanRow(rows, &count)) {
+		assert.Equal(t, int64(2), count)
+	}
+
+	rows, err = db.Query(`SELECT * FROM users WHERE id = ?`, "3")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.ErrorIs(t, ScanRow(rows, &user), sql.ErrNoRows)
+}
@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// SqlValuesOption selects the struct fields listed by StructSqlValues
+type SqlValuesOption struct {
+	// SkipZero omits fields holding their zero value, or a driver.Valuer returning nil
+	SkipZero bool
+	// SkipPrimaryKey omits fields tagged `db:"id,pk"`
+	SkipPrimaryKey bool
+	// SkipReadOnly omits fields tagged `db:"created,readonly"`, e.g. columns set by the database
+	SkipReadOnly bool
+}
+
+// SqlValues holds the columns of struct fields and the arguments of their values
+type SqlValues struct {
+	Columns []string
+	Args    []interface{}
+}
+
+// StructSqlValues lists the columns and values of the fields of a struct, including embedded ones.
+// Columns are named by `db` tag or by the snake_case field name, fields tagged `db:"-"` or
+// `copier:"-"` are left out and driver.Valuer values are resolved. Nested structs other than time.Time
+// have to implement driver.Valuer, or be embedded to list their fields.
+func StructSqlValues(value interface{}, opt SqlValuesOption) (SqlValues, error) {
+	source := Indirect(reflect.ValueOf(value))
+	if source.Kind() != reflect.Struct {
+		return SqlValues{}, fmt.Errorf("cannot list sql values of %T", value)
+	}
+
+	var values SqlValues
+	// a field shadowing embedded fields of the same name is listed with its own tag
+	for _, field := range visibleFields(source.Type()) {
+		if !field.IsExported() || parseCopierTag(field).Ignore {
+			continue
+		}
+
+		column, pk, readOnly := parseDbTag(field)
+		if column == "-" || (pk && opt.SkipPrimaryKey) || (readOnly && opt.SkipReadOnly) {
+			continue
+		}
+		arg, err := sqlArg(source, field)
+		if err != nil {
+			return SqlValues{}, fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		if opt.SkipZero && (arg == nil || reflect.ValueOf(arg).IsZero()) {
+			continue
+		}
+		values.Columns = append(values.Columns, column)
+		values.Args = append(values.Args, arg)
+	}
+	return values, nil
+}
+
+// Placeholders renders one ? placeholder per column, e.g. ?, ?
+func (v SqlValues) Placeholders() string {
+	return strings.TrimSuffix(strings.Repeat("?, ", len(v.Columns)), ", ")
+}
+
+// Assignments renders the SET list of an UPDATE, e.g. user_name = ?, active = ?
+func (v SqlValues) Assignments() string {
+	assignments := make([]string, len(v.Columns))
+	for i, column := range v.Columns {
+		assignments[i] = column + " = ?"
+	}
+	return strings.Join(assignments, ", ")
+}
+
+// InsertQuery renders an INSERT of the columns into table, to execute with Args
+func (v SqlValues) InsertQuery(table string) string {
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(v.Columns, ", "), v.Placeholders())
+}
+
+// UpdateQuery renders an UPDATE of the columns of table, to execute with Args followed by the
+// arguments of the where condition
+func (v SqlValues) UpdateQuery(table, where string) string {
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s", table, v.Assignments(), where)
+}
+
+// Rebind replaces the ? placeholders of the query by the placeholders of the dialect
+func (dialect SqlDialect) Rebind(query string) string {
+	if dialect == DialectPostgres {
+		return rebindPostgres(query)
+	}
+	return query
+}
+
+// parseDbTag reads the column name and the pk and readonly options of a `db` tag
+func parseDbTag(field reflect.StructField) (column string, pk, readOnly bool) {
+	tag := strings.Split(field.Tag.Get("db"), ",")
+	for _, option := range tag[1:] {
+		switch strings.TrimSpace(option) {
+		case "pk":
+			pk = true
+		case "readonly":
+			readOnly = true
+		}
+	}
+	column = strings.TrimSpace(tag[0])
+	if column == "" {
+		column = snakeCase(parseCopierTag(field).CopyName(field.Name))
+	}
+	return column, pk, readOnly
+}
+
+// sqlArg returns the value of the field as a query argument, nil for nil pointers
+func sqlArg(source reflect.Value, field reflect.StructField) (interface{}, error) {
+	value, err := source.FieldByIndexErr(field.Index)
+	if err != nil {
+		// the field is promoted through a nil embedded pointer
+		return nil, nil
+	}
+	if valuer, ok := value.Interface().(driver.Valuer); ok {
+		if value.Kind() == reflect.Ptr && value.IsNil() {
+			return nil, nil
+		}
+		return valuer.Value()
+	}
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil, nil
+		}
+		value = value.Elem()
+	}
+	if value.CanAddr() {
+		if valuer, ok := value.Addr().Interface().(driver.Valuer); ok {
+			return valuer.Value()
+		}
+	}
+	if value.Kind() == reflect.Struct && value.Type() != timeType {
+		return nil, fmt.Errorf("cannot use %s as a sql value, it is no driver.Valuer", value.Type())
+	}
+	return value.Interface(), nil
+}
+
+// snakeCase turns UserID into user_id
+func snakeCase(name string) string {
+	words := splitWords(name)
+	for i, word := range words {
+		words[i] = strings.ToLower(word)
+	}
+	return strings.Join(words, "_")
+}
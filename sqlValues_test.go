@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type sqlValuesAudit struct {
+	Created time.Time `db:"created,readonly"`
+	Version int
+}
+
+type sqlValuesUser struct {
+	*sqlValuesAudit
+	ID        string `db:"id,pk"`
+	UserName  string
+	GivenName *string
+	Active    bool
+	Emails    sql.NullString `db:"emails"`
+	Internal  string         `db:"-"`
+	Ignored   string         `copier:"-"`
+	password  string
+}
+
+type failingValuer struct{}
+
+func (failingValuer) Value() (driver.Value, error) {
+	return nil, errors.New("no value")
+}
+
+func TestStructSqlValues(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	user := sqlValuesUser{
+		sqlValuesAudit: &sqlValuesAudit{Created: created, Version: 2},
+		ID:             "1",
+		UserName:       "jdoe",
+		GivenName:      StringPtr("John"),
+		Emails:         sql.NullString{String: "[]", Valid: true},
+		password:       "secret",
+	}
+
+	values, err := StructSqlValues(user, SqlValuesOption{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"created", "version", "id", "user_name", "given_name", "active", "emails"}, values.Columns)
+		assert.Equal(t, []interface{}{created, 2, "1", "jdoe", "John", false, "[]"}, values.Args)
+	}
+
+	values, err = StructSqlValues(&user, SqlValuesOption{SkipZero: true, SkipPrimaryKey: true, SkipReadOnly: true})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"version", "user_name", "given_name", "emails"}, values.Columns)
+		assert.Equal(t, []interface{}{2, "jdoe", "John", "[]"}, values.Args)
+	}
+
+	values, err = StructSqlValues(sqlValuesUser{ID: "2"}, SqlValuesOption{SkipZero: true})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"id"}, values.Columns)
+	}
+}
+
+func TestStructSqlValues_Errors(t *testing.T) {
+	_, err := StructSqlValues(1, SqlValuesOption{})
+	assert.EqualError(t, err, "cannot list sql values of int")
+
+	_, err = StructSqlValues(struct{ Data failingValuer }{}, SqlValuesOption{})
+	assert.EqualError(t, err, "field Data: no value")
+
+	_, err = StructSqlValues(struct{ Audit sqlValuesAudit }{}, SqlValuesOption{})
+	assert.EqualError(t, err, "field Audit: cannot use utils.sqlValuesAudit as a sql value, it is no driver.Valuer")
+}
+
+func TestStructSqlValues_Shadowed(t *testing.T) {
+	type shadowing struct {
+		sqlValuesAudit
+		Version int `db:"revision"`
+	}
+	values, err := StructSqlValues(shadowing{sqlValuesAudit: sqlValuesAudit{Version: 1}, Version: 2}, SqlValuesOption{SkipReadOnly: true})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"revision"}, values.Columns)
+		assert.Equal(t, []interface{}{2}, values.Args)
+	}
+}
+
+func TestSqlValues_Queries(t *testing.T) {
+	values := SqlValues{Columns: []string{"user_name", "active"}, Args: []interface{}{"jdoe", true}}
+
+	assert.Equal(t, "INSERT INTO users (user_name, active) VALUES (?, ?)", values.InsertQuery("users"))
+	assert.Equal(t, "UPDATE users SET user_name = ?, active = ? WHERE id = ?", values.UpdateQuery("users", "id = ?"))
+	assert.Equal(t, "UPDATE users SET user_name = $1, active = $2 WHERE id = $3",
+		DialectPostgres.Rebind(values.UpdateQuery("users", "id = ?")))
+}
+
+func TestStructSqlValues_RoundTrip(t *testing.T) {
+	db := newScanTestDB(t)
+	user := scanUser{ID: "3", UserName: "bwayne", Active: true, scanAudit: scanAudit{Version: 1}}
+
+	values, err := StructSqlValues(user, SqlValuesOption{SkipZero: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = db.Exec(values.InsertQuery("users"), values.Args...)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	user.UserName = "batman"
+	values, err = StructSqlValues(user, SqlValuesOption{SkipZero: true, SkipPrimaryKey: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = db.Exec(values.UpdateQuery("users", "id = ?"), append(values.Args, user.ID)...)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	rows, err := db.Query(`SELECT * FROM users WHERE id = ?`, user.ID)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var stored scanUser
+	if assert.NoError(t, ScanRow(rows, &stored)) {
+		assert.Equal(t, "batman", stored.UserName)
+		assert.True(t, stored.Active)
+	}
+}
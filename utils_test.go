@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"encoding/xml"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"reflect"
+	"runtime"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -150,8 +153,15 @@ func TestIsTimeType(t *testing.T) {
 }
 
 func TestIsCustomType(t *testing.T) {
+	// every named type outside package syscall is custom, os.File included
 	valueType := reflect.TypeOf(os.File{})
 	result := IsCustomType(valueType)
+	if !result {
+		t.Error("Invalid response, expecting[true], got[false]")
+	}
+
+	valueType = reflect.TypeOf(syscall.Errno(0))
+	result = IsCustomType(valueType)
 	if result {
 		t.Error("Invalid response, expecting[false], got[true]")
 	}
@@ -283,13 +293,13 @@ func TestGetenv(t *testing.T) {
 }
 
 func TestSafeAtoi(t *testing.T) {
-	result := SafeAtoi("123weq", 321)
-	if result != 321 {
-		t.Errorf("SafeAtoi test failed, expected[321], got[%d]", result)
+	fallback := 321
+	result := SafeAtoi("123weq", &fallback)
+	if *result != 321 {
+		t.Errorf("SafeAtoi test failed, expected[321], got[%d]", *result)
 	}
-	result = SafeAtoi("123", 321)
-	if result != 123 {
-		t.Errorf("SafeAtoi test failed, expected[123], got[%d]", result)
+	if result = SafeAtoi("123", &fallback); *result != 123 {
+		t.Errorf("SafeAtoi test failed, expected[123], got[%d]", *result)
 	}
 }
 
@@ -627,13 +637,16 @@ func TestSrc(t *testing.T) {
 			args: args{
 				callerDepth: 1,
 			},
-			want: "utils.TestSrc.func1 at utils_test.go:635",
+			// the function is named after the last element of the package path
+			want: "go-lib-utils.TestSrc.func1 at utils_test.go:%d",
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := Src(tt.args.callerDepth); got != tt.want {
-				t.Errorf("Src() = %v, want %v", got, tt.want)
+			// Src is called on the line following runtime.Caller
+			_, _, line, _ := runtime.Caller(0)
+			if got, want := Src(tt.args.callerDepth), fmt.Sprintf(tt.want, line+1); got != want {
+				t.Errorf("Src() = %v, want %v", got, want)
 			}
 		})
 	}